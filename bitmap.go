@@ -0,0 +1,107 @@
+package slotmachine
+
+import (
+	"io"
+	"iter"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Bitmap is a slot machine that only tracks whether slots are booked, and stores no value.
+type Bitmap[T constraints.Integer] interface {
+	Set(slotidx T) (uint, error)
+	Unset(slotidx T) (uint, error)
+	Book() (T, uint, error)
+	BookBatch(slotcount T) ([]T, uint, error)
+	IsFree(slotidx T) bool
+	DumpLayout()
+	DumpLayoutTo(w io.Writer) error
+	Layout() LayoutInfo
+	Subscribe(buffer int) (<-chan Event[T, struct{}], func())
+	OnLowWatermark(threshold Threshold, fn func(low bool, available uint))
+	OnExhausted(fn func())
+	OnRecovered(fn func())
+	Booked() iter.Seq[T]
+	Free() iter.Seq[T]
+	FreeRanges() iter.Seq2[T, T]
+	CountFree(lo T, hi T) (uint, error)
+	Rank(slotidx T) (uint, error)
+	Select(k uint) (T, error)
+	Resize(size int) error
+	Shrink(size int) error
+	SetBoundaries(b Boundaries) error
+	SetRanges(rs RangeSet) error
+	Stats() Stats
+}
+
+// bitmapMachine runs a slot machine over a slice of empty structs, which takes no memory
+type bitmapMachine[T constraints.Integer] struct {
+	SlotMachine[T, struct{}]
+	free freeTester[T]
+}
+
+// freeTester is implemented by every concurrency model, to test a single leaf bit
+type freeTester[T constraints.Integer] interface {
+	isFree(slotidx T) bool
+}
+
+// NewBitmap creates a Bitmap of capacity slots. Arguments and options are those of New.
+func NewBitmap[T constraints.Integer](
+	cmodel ConcurrencyModel,
+	capacity int,
+	bucketSize uint8,
+	boundaries *Boundaries,
+	opts ...Option,
+) (Bitmap[T], error) {
+	slice := make([]struct{}, capacity)
+	sm, err := New[T, struct{}](cmodel, &slice, struct{}{}, bucketSize, boundaries, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &bitmapMachine[T]{sm, sm.(freeTester[T])}, nil
+}
+
+func (b *bitmapMachine[T]) Set(slotidx T) (uint, error) {
+	return b.SlotMachine.Set(slotidx, struct{}{})
+}
+
+func (b *bitmapMachine[T]) Book() (T, uint, error) {
+	return b.BookAndSet(struct{}{})
+}
+
+func (b *bitmapMachine[T]) BookBatch(slotcount T) ([]T, uint, error) {
+	return b.BookAndSetBatch(slotcount, struct{}{})
+}
+
+func (b *bitmapMachine[T]) IsFree(slotidx T) bool {
+	return b.free.isFree(slotidx)
+}
+
+// isFree is false for slots that cannot be booked, as they are marked as taken
+func (s *SlotMachineStruct[T, V]) isFree(slotidx T) bool {
+	if s.checkBoundaries(slotidx) == OutOfBound {
+		return false
+	}
+	leafidx := len(*s.bucketLevels) - 1
+	bucket, offset := s.locate(leafidx, int(slotidx))
+	return (*s.bucketLevels)[leafidx][bucket]&(1<<offset) == 0
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) isFree(slotidx T) bool {
+	return s.st.isFree(slotidx)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) isFree(slotidx T) bool {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.isFree(slotidx)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) isFree(slotidx T) bool {
+	var free bool
+	s.call(func() {
+		free = s.st.isFree(slotidx)
+	})
+	return free
+}
@@ -0,0 +1,301 @@
+package slotmachine
+
+import (
+	"fmt"
+	"math/bits"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RangeSet describes usable slots that do not form a single interval:
+// several inclusive ranges, minus excluded slots.
+type RangeSet struct {
+	Ranges  []Boundaries `json:"ranges"`
+	Exclude []int        `json:"exclude,omitempty"`
+}
+
+// normalize validates a range set against a slice size, sorting and merging its ranges
+func (rs RangeSet) normalize(size int) (RangeSet, error) {
+	if len(rs.Ranges) == 0 {
+		return RangeSet{}, fmt.Errorf("SlotMachine: range set has no range")
+	}
+	ranges := slices.Clone(rs.Ranges)
+	for _, r := range ranges {
+		if r.Lower < 0 || r.Upper >= size || r.Lower > r.Upper {
+			return RangeSet{}, fmt.Errorf("SlotMachine: boundaries %d - %d do not fit a slice of %d slots", r.Lower, r.Upper, size)
+		}
+	}
+	slices.SortFunc(ranges, func(a, b Boundaries) int { return a.Lower - b.Lower })
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.Lower <= last.Upper+1 {
+			last.Upper = max(last.Upper, r.Upper)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	exclude := slices.Clone(rs.Exclude)
+	for _, slot := range exclude {
+		if slot < 0 || slot >= size {
+			return RangeSet{}, fmt.Errorf("SlotMachine: excluded slot %d does not fit a slice of %d slots", slot, size)
+		}
+	}
+	slices.Sort(exclude)
+	return RangeSet{Ranges: merged, Exclude: slices.Compact(exclude)}, nil
+}
+
+// span returns the smallest boundaries including every range
+func (rs RangeSet) span() Boundaries {
+	return Boundaries{rs.Ranges[0].Lower, rs.Ranges[len(rs.Ranges)-1].Upper}
+}
+
+// contains reports whether a slot is usable, in a normalized range set
+func (rs RangeSet) contains(slot int) bool {
+	i := sort.Search(len(rs.Ranges), func(i int) bool { return rs.Ranges[i].Upper >= slot })
+	if i == len(rs.Ranges) || slot < rs.Ranges[i].Lower {
+		return false
+	}
+	_, excluded := slices.BinarySearch(rs.Exclude, slot)
+	return !excluded
+}
+
+// runs returns the usable slots of a normalized range set, as ranges with no exclusion
+func (rs RangeSet) runs() []Boundaries {
+	var runs []Boundaries
+	exclude := rs.Exclude
+	for _, r := range rs.Ranges {
+		for len(exclude) > 0 && exclude[0] < r.Lower {
+			exclude = exclude[1:]
+		}
+		for len(exclude) > 0 && exclude[0] <= r.Upper {
+			if exclude[0] > r.Lower {
+				runs = append(runs, Boundaries{r.Lower, exclude[0] - 1})
+			}
+			r.Lower = exclude[0] + 1
+			exclude = exclude[1:]
+		}
+		if r.Lower <= r.Upper {
+			runs = append(runs, r)
+		}
+	}
+	return runs
+}
+
+func (rs RangeSet) String() string {
+	ranges := make([]string, len(rs.Ranges))
+	for i, r := range rs.Ranges {
+		ranges[i] = fmt.Sprintf("%d - %d", r.Lower, r.Upper)
+	}
+	if len(rs.Exclude) == 0 {
+		return strings.Join(ranges, ", ")
+	}
+	return fmt.Sprintf("%s (%d excluded)", strings.Join(ranges, ", "), len(rs.Exclude))
+}
+
+// BookedOutsideError lists booked slots that fall outside new boundaries.
+// Unless the change was refused, they stay booked, with their values, but cannot be set,
+// unset or listed until boundaries include them again.
+type BookedOutsideError[T any] struct {
+	Slots   []T
+	Refused bool
+}
+
+func (e *BookedOutsideError[T]) Error() string {
+	slots := make([]string, len(e.Slots))
+	for i, slot := range e.Slots {
+		slots[i] = fmt.Sprint(slot)
+	}
+	if e.Refused {
+		return fmt.Sprintf("SlotMachine: boundaries refused, booked slots would fall outside: %s", strings.Join(slots, ", "))
+	}
+	return fmt.Sprintf("SlotMachine: booked slots outside boundaries: %s", strings.Join(slots, ", "))
+}
+
+// WithStrictBoundaries makes SetBoundaries and SetRanges refuse changes that would leave booked slots outside.
+func WithStrictBoundaries() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithRanges restricts usable slots to a range set. New's boundaries must then be nil.
+func WithRanges(rs RangeSet) Option {
+	return func(o *options) {
+		o.ranges = &rs
+	}
+}
+
+// setBits sets, in a bitmap of bucketSize-bit words, the bits of slots lo to hi inclusive
+func setBits(words []uint64, bucketSize int, lo int, hi int) {
+	for slot := lo; slot <= hi; {
+		bucket, offset := slot/bucketSize, slot%bucketSize
+		count := min(bucketSize-offset, hi-slot+1)
+		words[bucket] |= (^uint64(0) >> (64 - count)) << offset
+		slot += count
+	}
+}
+
+// buildMask returns the leaf bits of slots that cannot be booked: padding, and slots outside the range set
+func (s *SlotMachineStruct[T, V]) buildMask(words int, rs RangeSet) []uint64 {
+	mask := make([]uint64, words)
+	for _, r := range rs.runs() {
+		setBits(mask, int(s.bucketSize), r.Lower, r.Upper)
+	}
+	for bucket := range mask {
+		mask[bucket] = ^mask[bucket] & s.full
+	}
+	return mask
+}
+
+// usableBefore returns the number of usable slots below slotidx
+func (s *SlotMachineStruct[T, V]) usableBefore(slotidx int) uint64 {
+	i := sort.Search(len(s.runs), func(i int) bool { return s.runs[i].Upper >= slotidx })
+	if i == len(s.runs) {
+		return uint64(s.usableSlots)
+	}
+	return s.runsBefore[i] + uint64(max(slotidx-s.runs[i].Lower, 0))
+}
+
+// initMask marks unusable slots as taken in a freshly built hierarchy
+func (s *SlotMachineStruct[T, V]) initMask() {
+	if s.ranges.Ranges == nil {
+		s.ranges = RangeSet{Ranges: []Boundaries{s.boundaries}}
+	}
+	levels := *s.bucketLevels
+	leaf := levels[len(levels)-1]
+	s.mask = s.buildMask(len(leaf), s.ranges)
+	for bucket := range leaf {
+		leaf[bucket] |= s.mask[bucket]
+	}
+	fillParents(levels, s.bucketSize, s.full)
+	s.remasked()
+}
+
+// remasked refreshes everything derived from the range set and the bookings
+func (s *SlotMachineStruct[T, V]) remasked() {
+	s.runs = s.ranges.runs()
+	s.runsBefore = make([]uint64, len(s.runs))
+	s.usableSlots = 0
+	for i, r := range s.runs {
+		s.runsBefore[i] = uint64(s.usableSlots)
+		s.usableSlots += uint(r.Upper - r.Lower + 1)
+	}
+	s.initCounts()
+	s.recount()
+}
+
+// relevel rebuilds the bucket hierarchy for a number of slots and a range set, keeping bookings.
+// Booked slots that end up outside the range set are remembered as orphans.
+// It must be called before the slice is replaced.
+func (s *SlotMachineStruct[T, V]) relevel(size int, rs RangeSet) {
+	oldLeaf := (*s.bucketLevels)[len(*s.bucketLevels)-1]
+	levels := buildLevels(size, s.bucketSize, s.full)
+	leaf := levels[len(levels)-1]
+	mask := s.buildMask(len(leaf), rs)
+
+	booked := make([]uint64, len(leaf))
+	for bucket := range min(len(leaf), len(oldLeaf)) {
+		booked[bucket] = oldLeaf[bucket] &^ s.mask[bucket]
+	}
+	for _, slot := range s.orphans {
+		if int(slot) < size {
+			booked[int(slot)/int(s.bucketSize)] |= 1 << (int(slot) % int(s.bucketSize))
+		}
+	}
+
+	s.orphans = nil
+	for bucket := range leaf {
+		for outside := booked[bucket] & mask[bucket]; outside != 0; outside &= outside - 1 {
+			s.orphans = append(s.orphans, T(bucket*int(s.bucketSize)+bits.TrailingZeros64(outside)))
+		}
+		leaf[bucket] = booked[bucket] | mask[bucket]
+	}
+	fillParents(levels, s.bucketSize, s.full)
+	*s.bucketLevels = levels
+	s.mask = mask
+}
+
+// outside returns the booked slots, orphans included, that a range set would leave out
+func (s *SlotMachineStruct[T, V]) outside(rs RangeSet) []T {
+	var slots []T
+	for slot := range s.bitmap().booked() {
+		if !rs.contains(int(slot)) {
+			slots = append(slots, slot)
+		}
+	}
+	for _, slot := range s.orphans {
+		if !rs.contains(int(slot)) {
+			slots = append(slots, slot)
+		}
+	}
+	slices.Sort(slots)
+	return slots
+}
+
+func (s *SlotMachineStruct[T, V]) setRanges(rs RangeSet) error {
+	rs, err := rs.normalize(len(*s.slice))
+	if err != nil {
+		return err
+	}
+	if s.strict {
+		if slots := s.outside(rs); len(slots) > 0 {
+			return &BookedOutsideError[T]{Slots: slots, Refused: true}
+		}
+	}
+	s.relevel(len(*s.slice), rs)
+	s.ranges = rs
+	s.boundaries = rs.span()
+	s.remasked()
+	if s.metrics != nil {
+		s.metrics.Available(s.available)
+	}
+	s.checkWatermarks()
+	if len(s.orphans) > 0 {
+		return &BookedOutsideError[T]{Slots: slices.Clone(s.orphans)}
+	}
+	return nil
+}
+
+func (s *SlotMachineStruct[T, V]) setBoundaries(b Boundaries) error {
+	return s.setRanges(RangeSet{Ranges: []Boundaries{b}})
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) SetBoundaries(b Boundaries) error {
+	return s.st.setBoundaries(b)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) SetRanges(rs RangeSet) error {
+	return s.st.setRanges(rs)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) SetBoundaries(b Boundaries) error {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.setBoundaries(b)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) SetRanges(rs RangeSet) error {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.setRanges(rs)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) SetBoundaries(b Boundaries) error {
+	var err error
+	s.call(func() {
+		err = s.st.setBoundaries(b)
+	})
+	return err
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) SetRanges(rs RangeSet) error {
+	var err error
+	s.call(func() {
+		err = s.st.setRanges(rs)
+	})
+	return err
+}
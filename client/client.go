@@ -0,0 +1,420 @@
+// Package client talks to a slot machine served by the server package, such as slotmachined.
+// Client implements the slotmachine.SlotMachine interface.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fusion/slotmachine"
+	"golang.org/x/exp/constraints"
+)
+
+// Client is a remote slot machine. Values are sent as JSON.
+// Methods that cannot return an error, such as Layout or Booked, return nothing when the server
+// cannot be reached. Watermark callbacks are evaluated on this client's own operations.
+type Client[T constraints.Integer, V any] struct {
+	base       string
+	http       *http.Client
+	m          sync.Mutex
+	watermarks []*watermark
+}
+
+type watermark struct {
+	threshold slotmachine.Threshold
+	low       bool
+	fn        func(low bool, available uint)
+}
+
+// New creates a client for a server at address: a URL, a host:port, or unix:/path/to/socket.
+func New[T constraints.Integer, V any](address string) *Client[T, V] {
+	c := &Client[T, V]{base: address, http: &http.Client{}}
+	switch {
+	case strings.HasPrefix(address, "unix:"):
+		socket := strings.TrimPrefix(address, "unix:")
+		c.base = "http://unix"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	case !strings.Contains(address, "://"):
+		c.base = "http://" + address
+	}
+	c.base = strings.TrimSuffix(c.base, "/")
+	return c
+}
+
+// Error is an error reported by the server.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type errorResponse[T constraints.Integer] struct {
+	Error   string `json:"error"`
+	Outside []T    `json:"outside,omitempty"`
+	Refused bool   `json:"refused,omitempty"`
+}
+
+func (c *Client[T, V]) do(method string, path string, body any, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var failure errorResponse[T]
+	if json.Unmarshal(data, &failure) == nil && failure.Error != "" {
+		if failure.Outside != nil {
+			return &slotmachine.BookedOutsideError[T]{Slots: failure.Outside, Refused: failure.Refused}
+		}
+		return &Error{Status: resp.StatusCode, Message: failure.Error}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &Error{Status: resp.StatusCode, Message: fmt.Sprintf("slotmachine server: %s", resp.Status)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// Init does nothing: the server owns the machine.
+func (c *Client[T, V]) Init(*[]V, V, uint8, uint64, *[][]uint64, *slotmachine.Boundaries) {}
+
+type valueRequest[V any] struct {
+	Value V `json:"value"`
+}
+
+type availableResponse struct {
+	Available uint `json:"available"`
+}
+
+func (c *Client[T, V]) Set(slotidx T, value V) (uint, error) {
+	var resp availableResponse
+	err := c.do(http.MethodPut, fmt.Sprintf("/slots/%d", slotidx), valueRequest[V]{value}, &resp)
+	c.checkWatermarks(resp.Available, err)
+	return resp.Available, err
+}
+
+func (c *Client[T, V]) Unset(slotidx T) (uint, error) {
+	var resp availableResponse
+	err := c.do(http.MethodDelete, fmt.Sprintf("/slots/%d", slotidx), nil, &resp)
+	c.checkWatermarks(resp.Available, err)
+	return resp.Available, err
+}
+
+func (c *Client[T, V]) BookAndSet(value V) (T, uint, error) {
+	var resp struct {
+		Slot      T    `json:"slot"`
+		Available uint `json:"available"`
+	}
+	err := c.do(http.MethodPost, "/book", valueRequest[V]{value}, &resp)
+	c.checkWatermarks(resp.Available, err)
+	return resp.Slot, resp.Available, err
+}
+
+func (c *Client[T, V]) BookAndSetBatch(slotcount T, value V) ([]T, uint, error) {
+	var resp struct {
+		Slots     []T  `json:"slots"`
+		Available uint `json:"available"`
+	}
+	err := c.do(http.MethodPost, "/book/range", struct {
+		Count T `json:"count"`
+		Value V `json:"value"`
+	}{slotcount, value}, &resp)
+	c.checkWatermarks(resp.Available, err)
+	return resp.Slots, resp.Available, err
+}
+
+func (c *Client[T, V]) DumpLayout() {
+	c.DumpLayoutTo(os.Stdout)
+}
+
+func (c *Client[T, V]) DumpLayoutTo(w io.Writer) error {
+	var info slotmachine.LayoutInfo
+	if err := c.do(http.MethodGet, "/stats", nil, &info); err != nil {
+		return err
+	}
+	return info.WriteText(w)
+}
+
+func (c *Client[T, V]) Layout() slotmachine.LayoutInfo {
+	var info slotmachine.LayoutInfo
+	c.do(http.MethodGet, "/stats", nil, &info)
+	return info
+}
+
+// Subscribe streams the server's events. The channel is closed when the stream ends.
+func (c *Client[T, V]) Subscribe(buffer int) (<-chan slotmachine.Event[T, V], func()) {
+	events := make(chan slotmachine.Event[T, V], buffer)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/events", nil)
+	if err != nil {
+		close(events)
+		return events, cancel
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		close(events)
+		return events, cancel
+	}
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			var event slotmachine.Event[T, V]
+			if json.Unmarshal(scanner.Bytes(), &event) != nil {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events, cancel
+}
+
+func (c *Client[T, V]) OnLowWatermark(threshold slotmachine.Threshold, fn func(low bool, available uint)) {
+	info := c.Layout()
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.watermarks = append(c.watermarks, &watermark{
+		threshold: threshold,
+		low:       info.Available <= slotsFor(threshold, info.Usable),
+		fn:        fn,
+	})
+}
+
+func (c *Client[T, V]) OnExhausted(fn func()) {
+	c.OnLowWatermark(slotmachine.AbsoluteThreshold(0), func(low bool, available uint) {
+		if low {
+			fn()
+		}
+	})
+}
+
+func (c *Client[T, V]) OnRecovered(fn func()) {
+	c.OnLowWatermark(slotmachine.AbsoluteThreshold(0), func(low bool, available uint) {
+		if !low {
+			fn()
+		}
+	})
+}
+
+func slotsFor(threshold slotmachine.Threshold, usable uint) uint {
+	if threshold.Percent != 0 {
+		return uint(threshold.Percent / 100 * float64(usable))
+	}
+	return threshold.Slots
+}
+
+func (c *Client[T, V]) checkWatermarks(available uint, err error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if err != nil || len(c.watermarks) == 0 {
+		return
+	}
+	usable := available
+	for _, w := range c.watermarks {
+		if w.threshold.Percent != 0 {
+			usable = c.Layout().Usable
+			break
+		}
+	}
+	for _, w := range c.watermarks {
+		level := slotsFor(w.threshold, usable)
+		if low := available <= level; low != w.low {
+			w.low = low
+			w.fn(low, available)
+		}
+	}
+}
+
+func (c *Client[T, V]) booked() []slotmachine.SnapshotSlot[T, V] {
+	var booked []slotmachine.SnapshotSlot[T, V]
+	c.do(http.MethodGet, "/slots", nil, &booked)
+	return booked
+}
+
+func (c *Client[T, V]) freeRanges() []slotmachine.Boundaries {
+	var ranges []slotmachine.Boundaries
+	c.do(http.MethodGet, "/free", nil, &ranges)
+	return ranges
+}
+
+// Iterators work on a snapshot fetched when they are created
+
+func (c *Client[T, V]) All() iter.Seq2[T, V] {
+	booked := c.booked()
+	return func(yield func(T, V) bool) {
+		for _, b := range booked {
+			if !yield(b.Slot, b.Value) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client[T, V]) Booked() iter.Seq[T] {
+	booked := c.booked()
+	return func(yield func(T) bool) {
+		for _, b := range booked {
+			if !yield(b.Slot) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client[T, V]) Free() iter.Seq[T] {
+	ranges := c.freeRanges()
+	return func(yield func(T) bool) {
+		for _, r := range ranges {
+			for slot := r.Lower; slot <= r.Upper; slot++ {
+				if !yield(T(slot)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Client[T, V]) FreeRanges() iter.Seq2[T, T] {
+	ranges := c.freeRanges()
+	return func(yield func(T, T) bool) {
+		for _, r := range ranges {
+			if !yield(T(r.Lower), T(r.Upper)) {
+				return
+			}
+		}
+	}
+}
+
+// Find and FindAll fetch the booked slots, and run the predicate locally
+
+func (c *Client[T, V]) Find(pred func(T, V) bool) (T, bool) {
+	for slot, value := range c.All() {
+		if pred(slot, value) {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+func (c *Client[T, V]) FindAll(pred func(T, V) bool) []T {
+	var found []T
+	for slot, value := range c.All() {
+		if pred(slot, value) {
+			found = append(found, slot)
+		}
+	}
+	return found
+}
+
+func (c *Client[T, V]) CountFree(lo T, hi T) (uint, error) {
+	var resp struct {
+		Free uint `json:"free"`
+	}
+	err := c.do(http.MethodGet, "/count?"+url.Values{"lo": {fmt.Sprint(lo)}, "hi": {fmt.Sprint(hi)}}.Encode(), nil, &resp)
+	return resp.Free, err
+}
+
+func (c *Client[T, V]) Rank(slotidx T) (uint, error) {
+	var resp struct {
+		Rank uint `json:"rank"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/rank/%d", slotidx), nil, &resp)
+	return resp.Rank, err
+}
+
+func (c *Client[T, V]) Select(k uint) (T, error) {
+	var resp struct {
+		Slot T `json:"slot"`
+	}
+	err := c.do(http.MethodGet, fmt.Sprintf("/select/%d", k), nil, &resp)
+	return resp.Slot, err
+}
+
+// Grow cannot hand a slice to a remote machine: use Resize.
+func (c *Client[T, V]) Grow(newSlice *[]V) error {
+	return errors.New("slotmachine client: cannot grow a remote machine with a slice, use Resize")
+}
+
+func (c *Client[T, V]) Resize(size int) error {
+	return c.do(http.MethodPost, "/resize", map[string]any{"size": size}, nil)
+}
+
+func (c *Client[T, V]) Shrink(size int) error {
+	return c.do(http.MethodPost, "/resize", map[string]any{"size": size, "shrink": true}, nil)
+}
+
+func (c *Client[T, V]) SetBoundaries(b slotmachine.Boundaries) error {
+	return c.SetRanges(slotmachine.RangeSet{Ranges: []slotmachine.Boundaries{b}})
+}
+
+func (c *Client[T, V]) SetRanges(rs slotmachine.RangeSet) error {
+	return c.do(http.MethodPut, "/ranges", rs, nil)
+}
+
+func (c *Client[T, V]) Get(slotidx T) (V, bool) {
+	var resp struct {
+		Value  V    `json:"value"`
+		Booked bool `json:"booked"`
+	}
+	c.do(http.MethodGet, fmt.Sprintf("/slots/%d", slotidx), nil, &resp)
+	return resp.Value, resp.Booked
+}
+
+func (c *Client[T, V]) Snapshot() *slotmachine.Snapshot[T, V] {
+	var snap slotmachine.Snapshot[T, V]
+	if err := c.do(http.MethodGet, "/snapshot", nil, &snap); err != nil {
+		return nil
+	}
+	return &snap
+}
+
+func (c *Client[T, V]) Stats() slotmachine.Stats {
+	var st slotmachine.Stats
+	c.do(http.MethodGet, "/fragmentation", nil, &st)
+	return st
+}
+
+var _ slotmachine.SlotMachine[uint32, string] = (*Client[uint32, string])(nil)
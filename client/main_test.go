@@ -0,0 +1,130 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fusion/slotmachine"
+	"github.com/fusion/slotmachine/server"
+)
+
+type service struct {
+	ID string `json:"id"`
+}
+
+func newServer(t *testing.T, size int) *httptest.Server {
+	slice := make([]json.RawMessage, size)
+	sm, err := slotmachine.New[server.Slot, json.RawMessage](slotmachine.SyncConcurrency, &slice, nil, uint8(16), &slotmachine.Boundaries{Lower: 10, Upper: size - 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server.New(sm))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestClient(t *testing.T) {
+	t.Log("Testing the client against a server")
+
+	ts := newServer(t, 100)
+	var sm slotmachine.SlotMachine[uint16, service] = New[uint16, service](ts.URL)
+
+	events, cancel := sm.Subscribe(16)
+	defer cancel()
+
+	slot, available, err := sm.BookAndSet(service{"db"})
+	if err != nil || slot != 10 || available != 89 {
+		t.Errorf("expected to book slot 10 with 89 left, got %d, %d (%v)", slot, available, err)
+	}
+	slots, available, err := sm.BookAndSetBatch(3, service{"web"})
+	if err != nil || !slices.Equal(slots, []uint16{11, 12, 13}) || available != 86 {
+		t.Errorf("unexpected batch %v, %d (%v)", slots, available, err)
+	}
+	if _, err := sm.Set(50, service{"cache"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sm.Set(5, service{"nope"}); err == nil {
+		t.Error("setting a slot out of bounds should fail")
+	}
+	if value, booked := sm.Get(50); !booked || value.ID != "cache" {
+		t.Error("unexpected value", value, booked)
+	}
+	if _, err := sm.Unset(12); err != nil {
+		t.Fatal(err)
+	}
+	if found := sm.FindAll(func(_ uint16, s service) bool { return s.ID == "web" }); !slices.Equal(found, []uint16{11, 13}) {
+		t.Error("unexpected slots found", found)
+	}
+	if booked := slices.Collect(sm.Booked()); !slices.Equal(booked, []uint16{10, 11, 13, 50}) {
+		t.Error("unexpected booked slots", booked)
+	}
+	if first, _ := sm.Select(0); first != 12 {
+		t.Error("the first free slot should be 12, got", first)
+	}
+	if free, _ := sm.CountFree(0, 99); free != 86 {
+		t.Error("unexpected free slots", free)
+	}
+	if rank, _ := sm.Rank(50); rank != 3 {
+		t.Error("unexpected rank", rank)
+	}
+	if info := sm.Layout(); info.SliceSize != 100 || info.Available != 86 {
+		t.Errorf("unexpected layout %+v", info)
+	}
+
+	err = sm.SetBoundaries(slotmachine.Boundaries{Lower: 11, Upper: 99})
+	var outside *slotmachine.BookedOutsideError[uint16]
+	if !errors.As(err, &outside) || !slices.Equal(outside.Slots, []uint16{10}) {
+		t.Error("expected slot 10 to be reported outside, got", err)
+	}
+	if err := sm.Resize(200); err != nil {
+		t.Fatal(err)
+	}
+	if err := sm.Shrink(40); err == nil {
+		t.Error("shrinking over a booked slot should fail")
+	}
+	if err := sm.Grow(nil); err == nil {
+		t.Error("growing with a slice should fail")
+	}
+	if snap := sm.Snapshot(); snap == nil || snap.Size != 200 || len(snap.Slots) != 3 {
+		t.Errorf("unexpected snapshot %+v", snap)
+	}
+
+	for _, expected := range []slotmachine.TransactionType{slotmachine.TransactionBookAndSet, slotmachine.TransactionBookAndSet} {
+		select {
+		case event := <-events:
+			if event.Op != expected || event.New.ID == "" {
+				t.Errorf("unexpected event %+v", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+		}
+	}
+}
+
+func TestClientWatermarks(t *testing.T) {
+	t.Log("Testing watermarks evaluated by the client")
+
+	ts := newServer(t, 20)
+	sm := New[uint16, int](ts.URL)
+	var exhausted, recovered int
+	sm.OnExhausted(func() { exhausted++ })
+	sm.OnRecovered(func() { recovered++ })
+	for {
+		if _, _, err := sm.BookAndSet(1); err != nil {
+			break
+		}
+	}
+	sm.Unset(10)
+	if exhausted != 1 || recovered != 1 {
+		t.Error("unexpected callbacks", exhausted, recovered)
+	}
+
+	unreachable := New[uint16, int]("127.0.0.1:1")
+	if _, _, err := unreachable.BookAndSet(1); err == nil {
+		t.Error("booking without a server should fail")
+	}
+}
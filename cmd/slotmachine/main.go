@@ -0,0 +1,226 @@
+// Command slotmachine creates, inspects and changes a slot machine saved as a snapshot,
+// such as the state file of slotmachined.
+//
+//	slotmachine -state ports.json -size 65536 -lower 1024 book '{"owner": "db"}'
+//	slotmachine -state ports.json set 8080 web
+//	slotmachine -state ports.json unset 8080
+//	slotmachine -state ports.json list --free
+//	slotmachine -state ports.json stats
+//	slotmachine -state ports.json layout
+//	slotmachine -state ports.json visualize
+//
+// Without -state, or when the file does not exist yet, a machine is created from the
+// -size, -bucket, -lower and -upper flags. Changes are saved back to the state file.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/fusion/slotmachine"
+	"github.com/fusion/slotmachine/server"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "slotmachine:", err)
+		os.Exit(1)
+	}
+}
+
+const usage = `usage: slotmachine [flags] command [arguments]
+
+commands:
+  book [-count n] [value]   book the first free slot, or n slots
+  set slot [value]          book a given slot
+  unset slot                release a slot
+  stats                     show how many slots are available, and how fragmented they are
+  layout                    show the bucket hierarchy
+  list --free | --booked    list free runs, or booked slots and their values
+  visualize [-svg | -png]   draw how full the buckets below the root are
+
+Values are JSON; anything else is taken as a string.
+
+flags:
+`
+
+func run(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("slotmachine", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprint(out, usage)
+		flags.PrintDefaults()
+	}
+	state := flags.String("state", "", "snapshot file to load, and to save changes to")
+	size := flags.Int("size", 65536, "number of slots, for a new machine")
+	bucketSize := flags.Uint("bucket", 64, "bucket size, for a new machine")
+	lower := flags.Int("lower", 0, "lowest usable slot, for a new machine")
+	upper := flags.Int("upper", -1, "highest usable slot, for a new machine; -1 for the last one")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command")
+	}
+
+	var sm server.Machine
+	if *state != "" {
+		loaded, err := server.Load(slotmachine.NoConcurrency, *state)
+		if err != nil {
+			return err
+		}
+		sm = loaded
+	}
+	if sm == nil {
+		// Checked before converting, so that 320 is not taken for 64
+		if *bucketSize > 64 {
+			return fmt.Errorf("bucket size %d is larger than 64", *bucketSize)
+		}
+		if *upper < 0 {
+			*upper = *size - 1
+		}
+		slice := make([]json.RawMessage, *size)
+		created, err := slotmachine.New[server.Slot, json.RawMessage](slotmachine.NoConcurrency, &slice, nil, uint8(*bucketSize), &slotmachine.Boundaries{Lower: *lower, Upper: *upper})
+		if err != nil {
+			return err
+		}
+		sm = created
+	}
+
+	changed, err := command(sm, flags.Arg(0), flags.Args()[1:], out)
+	if err != nil || !changed || *state == "" {
+		return err
+	}
+	return server.New(sm, server.WithState(*state)).Save()
+}
+
+// command runs a command, and reports whether it changed the machine
+func command(sm server.Machine, name string, args []string, out io.Writer) (bool, error) {
+	switch name {
+	case "book":
+		flags := flag.NewFlagSet("book", flag.ContinueOnError)
+		count := flags.Uint("count", 1, "number of slots to book")
+		if err := flags.Parse(args); err != nil {
+			return false, err
+		}
+		slots, available, err := sm.BookAndSetBatch(server.Slot(*count), value(flags.Arg(0)))
+		if err != nil {
+			return false, err
+		}
+		for _, slot := range slots {
+			fmt.Fprintln(out, slot)
+		}
+		fmt.Fprintf(out, "available: %d\n", available)
+		return true, nil
+	case "set":
+		if len(args) == 0 {
+			return false, errors.New("set: missing slot")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return false, err
+		}
+		var v string
+		if len(args) > 1 {
+			v = args[1]
+		}
+		available, err := sm.Set(slot, value(v))
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(out, "available: %d\n", available)
+		return true, nil
+	case "unset":
+		if len(args) == 0 {
+			return false, errors.New("unset: missing slot")
+		}
+		slot, err := parseSlot(args[0])
+		if err != nil {
+			return false, err
+		}
+		available, err := sm.Unset(slot)
+		if err != nil {
+			return false, err
+		}
+		fmt.Fprintf(out, "available: %d\n", available)
+		return true, nil
+	case "stats":
+		info := sm.Layout()
+		st := sm.Stats()
+		fmt.Fprintf(out, "slots: %d\nusable: %s\navailable: %d/%d (%.2f%% full)\nfree runs: %d (largest: %d)\n",
+			info.SliceSize, info.Ranges, info.Available, info.Usable, info.FillRatio*100, st.FreeRuns, st.LargestFreeRun)
+		return false, nil
+	case "layout":
+		return false, sm.DumpLayoutTo(out)
+	case "list":
+		flags := flag.NewFlagSet("list", flag.ContinueOnError)
+		free := flags.Bool("free", false, "list runs of free slots")
+		booked := flags.Bool("booked", false, "list booked slots and their values")
+		if err := flags.Parse(args); err != nil {
+			return false, err
+		}
+		if *free == *booked {
+			return false, errors.New("list: choose one of --free or --booked")
+		}
+		if *free {
+			for first, last := range sm.FreeRanges() {
+				if first == last {
+					fmt.Fprintln(out, first)
+				} else {
+					fmt.Fprintf(out, "%d-%d\n", first, last)
+				}
+			}
+			return false, nil
+		}
+		for slot, v := range sm.All() {
+			fmt.Fprintf(out, "%d\t%s\n", slot, v)
+		}
+		return false, nil
+	case "visualize":
+		flags := flag.NewFlagSet("visualize", flag.ContinueOnError)
+		width := flags.Int("width", 64, "buckets per line")
+		svg := flags.Bool("svg", false, "draw an SVG image")
+		png := flags.Bool("png", false, "draw a PNG image")
+		cell := flags.Int("cell", 8, "pixels per bucket, for images")
+		if err := flags.Parse(args); err != nil {
+			return false, err
+		}
+		st := sm.Stats()
+		switch {
+		case *svg:
+			return false, st.WriteSVG(out, *width, *cell)
+		case *png:
+			return false, st.WritePNG(out, *width, *cell)
+		default:
+			return false, st.WriteHeatmap(out, *width)
+		}
+	default:
+		return false, fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// value takes JSON as is, and anything else as a string
+func value(arg string) json.RawMessage {
+	if arg == "" {
+		return nil
+	}
+	if json.Valid([]byte(arg)) {
+		return json.RawMessage(arg)
+	}
+	quoted, _ := json.Marshal(arg)
+	return quoted
+}
+
+func parseSlot(arg string) (server.Slot, error) {
+	slot, err := strconv.ParseUint(arg, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid slot %q", arg)
+	}
+	return server.Slot(slot), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCommands(t *testing.T) {
+	t.Log("Testing commands against a state file")
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	flags := []string{"-state", state, "-size", "256", "-bucket", "16", "-lower", "16"}
+	for _, call := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"book", "-count", "2", "db"}, "16\n17\navailable: 238\n"},
+		{[]string{"set", "100", `{"port": 100}`}, "available: 237\n"},
+		{[]string{"unset", "16"}, "available: 238\n"},
+		{[]string{"list", "--booked"}, "17\t\"db\"\n100\t{\"port\":100}\n"},
+		{[]string{"list", "--free"}, "16\n18-99\n101-255\n"},
+		{[]string{"stats"}, "slots: 256\nusable: 16 - 255\navailable: 238/240 (0.83% full)\nfree runs: 3 (largest: 155)\n"},
+		{[]string{"visualize", "-width", "8"}, "         0  :....:.\n       128 ........\n"},
+	} {
+		var out strings.Builder
+		if err := run(append(flags, call.args...), &out); err != nil {
+			t.Fatal(call.args, err)
+		}
+		if out.String() != call.expected {
+			t.Errorf("%v: got %q", call.args, out.String())
+		}
+	}
+
+	var out strings.Builder
+	if err := run([]string{"-state", state, "layout"}, &out); err != nil || !strings.HasPrefix(out.String(), "Slice size: 256 (Usable slots: 16 - 255)\nBucket size: 16\n") {
+		t.Error("unexpected layout", out.String(), err)
+	}
+	for _, args := range [][]string{
+		{"-state", state, "set", "3"},
+		{"-state", state, "unset", "x"},
+		{"-state", state, "list"},
+		{"-state", state, "frobnicate"},
+		{"-bucket", "320", "stats"},
+		{},
+	} {
+		if err := run(args, &out); err == nil {
+			t.Error("expected an error for", args)
+		}
+	}
+}
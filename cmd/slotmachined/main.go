@@ -0,0 +1,103 @@
+// Command slotmachined serves a slot machine over HTTP, on TCP or a Unix socket.
+//
+//	slotmachined -listen 127.0.0.1:7777 -size 65536 -lower 1024 -state /var/lib/slotmachined/ports.json
+//	slotmachined -listen unix:/run/slotmachined.sock -size 4096
+//
+// See the server package for the API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fusion/slotmachine"
+	"github.com/fusion/slotmachine/server"
+)
+
+var models = map[string]slotmachine.ConcurrencyModel{
+	"sync":    slotmachine.SyncConcurrency,
+	"channel": slotmachine.ChannelConcurrency,
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:7777", "address to serve on: host:port, or unix:/path/to/socket")
+	size := flag.Int("size", 65536, "number of slots")
+	bucketSize := flag.Uint("bucket", 64, "bucket size: a power of 2, from 2 to 64")
+	lower := flag.Int("lower", 0, "lowest usable slot")
+	upper := flag.Int("upper", -1, "highest usable slot; -1 for the last one")
+	model := flag.String("model", "sync", "concurrency model: sync or channel, as requests are served concurrently")
+	state := flag.String("state", "", "file to persist the machine to, and to restore it from")
+	flag.Parse()
+
+	if err := run(*listen, *size, *bucketSize, *lower, *upper, *model, *state); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(listen string, size int, bucketSize uint, lower int, upper int, model string, state string) error {
+	cmodel, ok := models[model]
+	if !ok {
+		return fmt.Errorf("unknown concurrency model %q", model)
+	}
+
+	var sm server.Machine
+	if state != "" {
+		restored, err := server.Load(cmodel, state)
+		if err != nil {
+			return err
+		}
+		sm = restored
+	}
+	if sm == nil {
+		// Checked before converting, so that 320 is not taken for 64
+		if bucketSize > 64 {
+			return fmt.Errorf("bucket size %d is larger than 64", bucketSize)
+		}
+		if upper < 0 {
+			upper = size - 1
+		}
+		slice := make([]json.RawMessage, size)
+		created, err := slotmachine.New[server.Slot, json.RawMessage](cmodel, &slice, nil, uint8(bucketSize), &slotmachine.Boundaries{Lower: lower, Upper: upper})
+		if err != nil {
+			return err
+		}
+		sm = created
+	}
+	srv := server.New(sm, server.WithState(state))
+	if err := srv.Save(); err != nil {
+		return err
+	}
+
+	network, address := "tcp", listen
+	if strings.HasPrefix(listen, "unix:") {
+		network, address = "unix", strings.TrimPrefix(listen, "unix:")
+		os.Remove(address)
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return err
+	}
+	httpServer := &http.Server{Handler: srv}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+	log.Printf("serving %d slots on %s", sm.Layout().SliceSize, listen)
+	if err := httpServer.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
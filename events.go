@@ -0,0 +1,120 @@
+package slotmachine
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/exp/constraints"
+)
+
+// Event describes a successful change to a slot.
+// Op is TransactionSet, TransactionUnset or TransactionBookAndSet.
+type Event[T constraints.Integer, V any] struct {
+	Op   TransactionType
+	Slot T
+	Old  V
+	New  V
+	Time time.Time
+}
+
+type SubscriberPolicy uint8
+
+const (
+	// DropEvents discards events a subscriber has no buffer room for
+	DropEvents SubscriberPolicy = iota
+	// BlockOnEvents holds the operation until every subscriber has received its event
+	BlockOnEvents
+)
+
+// WithSubscriberPolicy sets what happens when a subscriber falls behind. The default is DropEvents.
+// With BlockOnEvents, a stalled subscriber stalls the whole machine.
+func WithSubscriberPolicy(policy SubscriberPolicy) Option {
+	return func(o *options) {
+		o.policy = policy
+	}
+}
+
+type subscriber[T constraints.Integer, V any] struct {
+	events chan Event[T, V]
+	done   chan struct{}
+	once   sync.Once
+}
+
+// Subscribers live under their own mutex: they are registered from any goroutine,
+// whatever the concurrency model, and cancelling must not wait for a blocked emit
+type subscribers[T constraints.Integer, V any] struct {
+	m      sync.Mutex
+	policy SubscriberPolicy
+	list   []*subscriber[T, V]
+}
+
+func (s *SlotMachineStruct[T, V]) subscribe(buffer int) (<-chan Event[T, V], func()) {
+	sub := &subscriber[T, V]{events: make(chan Event[T, V], buffer), done: make(chan struct{})}
+	s.subs.m.Lock()
+	s.subs.list = append(s.subs.list, sub)
+	s.subs.m.Unlock()
+
+	cancel := func() {
+		sub.once.Do(func() {
+			close(sub.done)
+			s.subs.m.Lock()
+			defer s.subs.m.Unlock()
+			for i, other := range s.subs.list {
+				if other == sub {
+					s.subs.list = append(s.subs.list[:i], s.subs.list[i+1:]...)
+					break
+				}
+			}
+			close(sub.events)
+		})
+	}
+	return sub.events, cancel
+}
+
+func (s *SlotMachineStruct[T, V]) watched() bool {
+	s.subs.m.Lock()
+	defer s.subs.m.Unlock()
+	return len(s.subs.list) > 0
+}
+
+// valueAt returns the value currently stored in a slot, or the empty value for an invalid index
+func (s *SlotMachineStruct[T, V]) valueAt(slotidx T) V {
+	if slotidx < 0 || uint64(slotidx) >= uint64(len(*s.slice)) {
+		return s.empty
+	}
+	return (*s.slice)[slotidx]
+}
+
+func (s *SlotMachineStruct[T, V]) emit(op TransactionType, slotidx T, old V, value V) {
+	s.subs.m.Lock()
+	defer s.subs.m.Unlock()
+	if len(s.subs.list) == 0 {
+		return
+	}
+	event := Event[T, V]{Op: op, Slot: slotidx, Old: old, New: value, Time: time.Now()}
+	for _, sub := range s.subs.list {
+		if s.subs.policy == BlockOnEvents {
+			select {
+			case sub.events <- event:
+			case <-sub.done:
+			}
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Subscribe(buffer int) (<-chan Event[T, V], func()) {
+	return s.st.subscribe(buffer)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Subscribe(buffer int) (<-chan Event[T, V], func()) {
+	return s.st.subscribe(buffer)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Subscribe(buffer int) (<-chan Event[T, V], func()) {
+	return s.st.subscribe(buffer)
+}
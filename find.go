@@ -0,0 +1,59 @@
+package slotmachine
+
+func (s *SlotMachineStruct[T, V]) find(pred func(T, V) bool) (T, bool) {
+	for slot, value := range s.all() {
+		if pred(slot, value) {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+func (s *SlotMachineStruct[T, V]) findAll(pred func(T, V) bool) []T {
+	var found []T
+	for slot, value := range s.all() {
+		if pred(slot, value) {
+			found = append(found, slot)
+		}
+	}
+	return found
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Find(pred func(T, V) bool) (T, bool) {
+	return s.st.find(pred)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) FindAll(pred func(T, V) bool) []T {
+	return s.st.findAll(pred)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Find(pred func(T, V) bool) (T, bool) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.find(pred)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) FindAll(pred func(T, V) bool) []T {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.findAll(pred)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Find(pred func(T, V) bool) (T, bool) {
+	var slot T
+	var found bool
+	s.call(func() {
+		slot, found = s.st.find(pred)
+	})
+	return slot, found
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) FindAll(pred func(T, V) bool) []T {
+	var found []T
+	s.call(func() {
+		found = s.st.findAll(pred)
+	})
+	return found
+}
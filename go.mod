@@ -1,5 +1,5 @@
 module github.com/fusion/slotmachine
 
-go 1.21rc4
+go 1.23
 
 require golang.org/x/exp v0.0.0-20230817173708-d852ddb80c63
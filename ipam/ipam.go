@@ -0,0 +1,188 @@
+// Package ipam hands out IP addresses from a prefix, using a slot machine to keep track of them.
+package ipam
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"github.com/fusion/slotmachine"
+)
+
+// MaxHostBits limits the size of a pool: a prefix may leave at most this many bits for hosts.
+const MaxHostBits = 24
+
+type options struct {
+	gateway    netip.Addr
+	hasGateway bool
+	exclude    []netip.Addr
+	machine    []slotmachine.Option
+}
+
+// Option configures a Pool.
+type Option func(*options)
+
+// WithGateway sets the gateway address, which is never handed out. The default is the first
+// address after the network address. Pass the zero netip.Addr to have no gateway.
+func WithGateway(gateway netip.Addr) Option {
+	return func(o *options) {
+		o.gateway = gateway
+		o.hasGateway = true
+	}
+}
+
+// WithExcluded adds addresses that are never handed out.
+func WithExcluded(addrs ...netip.Addr) Option {
+	return func(o *options) {
+		o.exclude = append(o.exclude, addrs...)
+	}
+}
+
+// WithMachineOptions passes options, such as a logger or metrics, to the underlying slot machine.
+func WithMachineOptions(opts ...slotmachine.Option) Option {
+	return func(o *options) {
+		o.machine = append(o.machine, opts...)
+	}
+}
+
+// Pool hands out the addresses of a prefix. It is safe for concurrent use.
+type Pool struct {
+	m      sync.Mutex
+	prefix netip.Prefix
+	bm     slotmachine.Bitmap[uint32]
+}
+
+// New creates a Pool over an IPv4 or IPv6 prefix. The network address, the IPv4 broadcast
+// address and the gateway are never handed out.
+func New(prefix netip.Prefix, opts ...Option) (*Pool, error) {
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("ipam: invalid prefix %s", prefix)
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > MaxHostBits {
+		return nil, fmt.Errorf("ipam: prefix %s has %d host bits, at most %d are supported", prefix, hostBits, MaxHostBits)
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	p := &Pool{prefix: prefix}
+	size := 1 << hostBits
+	var exclude []int
+	// Point-to-point IPv4 prefixes have neither network nor broadcast address
+	if prefix.Addr().Is6() || hostBits > 1 {
+		exclude = append(exclude, 0)
+	}
+	if prefix.Addr().Is4() && hostBits > 1 {
+		exclude = append(exclude, size-1)
+	}
+	if !o.hasGateway && size > 2 {
+		o.gateway = p.addr(1)
+	}
+	if o.gateway.IsValid() {
+		o.exclude = append(o.exclude, o.gateway)
+	}
+	for _, addr := range o.exclude {
+		slot, err := p.slot(addr)
+		if err != nil {
+			return nil, err
+		}
+		exclude = append(exclude, int(slot))
+	}
+
+	machine := append([]slotmachine.Option{slotmachine.WithRanges(slotmachine.RangeSet{
+		Ranges:  []slotmachine.Boundaries{{Lower: 0, Upper: size - 1}},
+		Exclude: exclude,
+	})}, o.machine...)
+	bucketSize := uint8(64)
+	if size < 64 {
+		bucketSize = uint8(max(size, 2))
+	}
+	bm, err := slotmachine.NewBitmap[uint32](slotmachine.NoConcurrency, size, bucketSize, nil, machine...)
+	if err != nil {
+		return nil, err
+	}
+	p.bm = bm
+	return p, nil
+}
+
+// addr returns the address of a slot: slots are offsets in the prefix
+func (p *Pool) addr(slot uint32) netip.Addr {
+	b := p.prefix.Addr().As16()
+	low := binary.BigEndian.Uint32(b[12:]) | slot
+	binary.BigEndian.PutUint32(b[12:], low)
+	addr := netip.AddrFrom16(b)
+	if p.prefix.Addr().Is4() {
+		return addr.Unmap()
+	}
+	return addr
+}
+
+func (p *Pool) slot(addr netip.Addr) (uint32, error) {
+	if !p.prefix.Contains(addr) {
+		return 0, fmt.Errorf("ipam: %s is not in %s", addr, p.prefix)
+	}
+	b := addr.As16()
+	base := p.prefix.Addr().As16()
+	return binary.BigEndian.Uint32(b[12:]) ^ binary.BigEndian.Uint32(base[12:]), nil
+}
+
+// Prefix returns the prefix addresses are handed out from.
+func (p *Pool) Prefix() netip.Prefix {
+	return p.prefix
+}
+
+// Allocate hands out the first free address.
+func (p *Pool) Allocate() (netip.Addr, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	slot, _, err := p.bm.Book()
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("ipam: %s is exhausted", p.prefix)
+	}
+	return p.addr(slot), nil
+}
+
+// AllocateSpecific hands out an address, if it is free.
+func (p *Pool) AllocateSpecific(addr netip.Addr) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	slot, err := p.slot(addr)
+	if err != nil {
+		return err
+	}
+	if !p.bm.IsFree(slot) {
+		return fmt.Errorf("ipam: %s is not available", addr)
+	}
+	_, err = p.bm.Set(slot)
+	return err
+}
+
+// Release returns an address to the pool.
+func (p *Pool) Release(addr netip.Addr) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	slot, err := p.slot(addr)
+	if err != nil {
+		return err
+	}
+	if p.bm.IsFree(slot) {
+		return fmt.Errorf("ipam: %s is not allocated", addr)
+	}
+	_, err = p.bm.Unset(slot)
+	return err
+}
+
+// Available returns the number of addresses left to hand out.
+func (p *Pool) Available() uint {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.bm.Layout().Available
+}
@@ -0,0 +1,88 @@
+package ipam
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	t.Log("Testing IPv4 allocations")
+
+	p, err := New(netip.MustParsePrefix("10.0.3.7/24"), WithExcluded(netip.MustParseAddr("10.0.3.53")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Prefix().String() != "10.0.3.0/24" || p.Available() != 256-4 {
+		t.Error("unexpected pool", p.Prefix(), p.Available())
+	}
+	addr, err := p.Allocate()
+	if err != nil || addr != netip.MustParseAddr("10.0.3.2") {
+		t.Errorf("expected 10.0.3.2 after the gateway, got %s (%v)", addr, err)
+	}
+	for _, s := range []string{"10.0.3.0", "10.0.3.1", "10.0.3.53", "10.0.3.255", "10.0.3.2", "10.0.4.1"} {
+		if err := p.AllocateSpecific(netip.MustParseAddr(s)); err == nil {
+			t.Errorf("%s should not be available", s)
+		}
+	}
+	if err := p.AllocateSpecific(netip.MustParseAddr("10.0.3.254")); err != nil {
+		t.Error(err)
+	}
+	if err := p.Release(netip.MustParseAddr("10.0.3.3")); err == nil {
+		t.Error("releasing a free address should fail")
+	}
+	if err := p.Release(addr); err != nil {
+		t.Error(err)
+	}
+	allocated := 0
+	for {
+		addr, err := p.Allocate()
+		if err != nil {
+			break
+		}
+		if addr == netip.MustParseAddr("10.0.3.53") || !p.Prefix().Contains(addr) {
+			t.Fatal("unexpected address", addr)
+		}
+		allocated++
+	}
+	if allocated != 256-5 {
+		t.Error("unexpected number of addresses", allocated)
+	}
+
+	p, _ = New(netip.MustParsePrefix("192.168.0.0/30"), WithGateway(netip.Addr{}))
+	if addr, _ := p.Allocate(); addr != netip.MustParseAddr("192.168.0.1") || p.Available() != 1 {
+		t.Error("without a gateway, the first address should be handed out", addr)
+	}
+
+	if _, err := New(netip.MustParsePrefix("10.0.0.0/7")); err == nil {
+		t.Error("a prefix this large should be refused")
+	}
+	if _, err := New(netip.MustParsePrefix("10.0.0.0/24"), WithGateway(netip.MustParseAddr("10.0.1.1"))); err == nil {
+		t.Error("a gateway outside the prefix should be refused")
+	}
+}
+
+func TestPool6(t *testing.T) {
+	t.Log("Testing IPv6 allocations")
+
+	p, err := New(netip.MustParsePrefix("fd00:1:2:3::/112"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Available() != 65536-2 {
+		t.Error("unexpected available addresses", p.Available())
+	}
+	addr, err := p.Allocate()
+	if err != nil || addr != netip.MustParseAddr("fd00:1:2:3::2") {
+		t.Errorf("expected fd00:1:2:3::2, got %s (%v)", addr, err)
+	}
+	last := netip.MustParseAddr("fd00:1:2:3::ffff")
+	if err := p.AllocateSpecific(last); err != nil {
+		t.Error("IPv6 has no broadcast address", err)
+	}
+	if err := p.AllocateSpecific(netip.MustParseAddr("10.0.0.1")); err == nil {
+		t.Error("an IPv4 address should not be in an IPv6 prefix")
+	}
+	if _, err := New(netip.MustParsePrefix("fd00::/64")); err == nil {
+		t.Error("a /64 should be refused")
+	}
+}
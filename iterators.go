@@ -0,0 +1,245 @@
+package slotmachine
+
+import (
+	"iter"
+
+	"golang.org/x/exp/constraints"
+)
+
+// bitmap is a read-only view of the bucket hierarchy, either live or a snapshot
+type bitmap[T constraints.Integer] struct {
+	levels     [][]uint64
+	size       int
+	bucketSize int
+	full       uint64
+	runs       []Boundaries
+}
+
+func (s *SlotMachineStruct[T, V]) bitmap() bitmap[T] {
+	return bitmap[T]{
+		levels:     *s.bucketLevels,
+		size:       len(*s.slice),
+		bucketSize: int(s.bucketSize),
+		full:       s.full,
+		runs:       s.runs,
+	}
+}
+
+func (s *SlotMachineStruct[T, V]) snapshot() bitmap[T] {
+	b := s.bitmap()
+	b.levels = make([][]uint64, len(*s.bucketLevels))
+	for i, level := range *s.bucketLevels {
+		b.levels[i] = append([]uint64(nil), level...)
+	}
+	return b
+}
+
+// walk reports the usable slots in order, as runs [lo, hi) that are either all booked or all free.
+// A parent bit set means the region under it is full, so it is reported without looking further down.
+func (b bitmap[T]) walk(fn func(lo int, hi int, booked bool) bool) {
+	for _, r := range b.runs {
+		if !b.walkRange(0, r.Lower, min(r.Upper+1, b.size), fn) {
+			return
+		}
+	}
+}
+
+func (b bitmap[T]) walkRange(levelidx int, lo int, hi int, fn func(int, int, bool) bool) bool {
+	level := b.levels[levelidx]
+	shift := levelShift(len(b.levels), levelidx, uint8(b.bucketSize))
+	sub := 1 << shift
+	span := b.bucketSize << shift
+	leaf := levelidx == len(b.levels)-1
+	for x := lo; x < hi; {
+		bucket := x / span
+		if leaf && level[bucket] == 0 || level[bucket] == b.full {
+			// Nothing, or everything, booked in this bucket
+			end := min((bucket+1)*span, hi)
+			if !fn(x, end, level[bucket] != 0) {
+				return false
+			}
+			x = end
+			continue
+		}
+		offset := (x % span) / sub
+		end := min(bucket*span+(offset+1)*sub, hi)
+		if level[bucket]&(1<<offset) != 0 {
+			if !fn(x, end, true) {
+				return false
+			}
+		} else if leaf {
+			if !fn(x, end, false) {
+				return false
+			}
+		} else if !b.walkRange(levelidx+1, x, end, fn) {
+			return false
+		}
+		x = end
+	}
+	return true
+}
+
+func (b bitmap[T]) booked() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		b.walk(func(lo int, hi int, booked bool) bool {
+			if !booked {
+				return true
+			}
+			for slot := lo; slot < hi; slot++ {
+				if !yield(T(slot)) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+func (b bitmap[T]) free() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		b.walk(func(lo int, hi int, booked bool) bool {
+			if booked {
+				return true
+			}
+			for slot := lo; slot < hi; slot++ {
+				if !yield(T(slot)) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+}
+
+// freeRanges yields inclusive [first, last] runs of free slots, merging adjacent runs
+func (b bitmap[T]) freeRanges() iter.Seq2[T, T] {
+	return func(yield func(T, T) bool) {
+		first, last := -1, -1
+		stopped := false
+		b.walk(func(lo int, hi int, booked bool) bool {
+			if booked {
+				return true
+			}
+			if first >= 0 && lo == last+1 {
+				last = hi - 1
+				return true
+			}
+			if first >= 0 && !yield(T(first), T(last)) {
+				stopped = true
+				return false
+			}
+			first, last = lo, hi-1
+			return true
+		})
+		if !stopped && first >= 0 {
+			yield(T(first), T(last))
+		}
+	}
+}
+
+func (s *SlotMachineStruct[T, V]) all() iter.Seq2[T, V] {
+	return func(yield func(T, V) bool) {
+		for slot := range s.bitmap().booked() {
+			if !yield(slot, (*s.slice)[slot]) {
+				return
+			}
+		}
+	}
+}
+
+// allSnapshot copies booked slots and their values, so they can be iterated on outside of the machine
+func (s *SlotMachineStruct[T, V]) allSnapshot() iter.Seq2[T, V] {
+	var slots []T
+	var values []V
+	for slot := range s.bitmap().booked() {
+		slots = append(slots, slot)
+		values = append(values, (*s.slice)[slot])
+	}
+	return func(yield func(T, V) bool) {
+		for i, slot := range slots {
+			if !yield(slot, values[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Without concurrency, iterators read the live machine: do not modify it while iterating
+
+func (s *NoConcurrencySlotMachine[T, V]) All() iter.Seq2[T, V] {
+	return s.st.all()
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Booked() iter.Seq[T] {
+	return s.st.bitmap().booked()
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Free() iter.Seq[T] {
+	return s.st.bitmap().free()
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) FreeRanges() iter.Seq2[T, T] {
+	return s.st.bitmap().freeRanges()
+}
+
+// With Sync and Channel concurrency, iterators work on a snapshot taken when they are created
+
+func (s *SyncConcurrencySlotMachine[T, V]) All() iter.Seq2[T, V] {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.allSnapshot()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Booked() iter.Seq[T] {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.snapshot().booked()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Free() iter.Seq[T] {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.snapshot().free()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) FreeRanges() iter.Seq2[T, T] {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.snapshot().freeRanges()
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) All() iter.Seq2[T, V] {
+	var seq iter.Seq2[T, V]
+	s.call(func() {
+		seq = s.st.allSnapshot()
+	})
+	return seq
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Booked() iter.Seq[T] {
+	var b bitmap[T]
+	s.call(func() {
+		b = s.st.snapshot()
+	})
+	return b.booked()
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Free() iter.Seq[T] {
+	var b bitmap[T]
+	s.call(func() {
+		b = s.st.snapshot()
+	})
+	return b.free()
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) FreeRanges() iter.Seq2[T, T] {
+	var b bitmap[T]
+	s.call(func() {
+		b = s.st.snapshot()
+	})
+	return b.freeRanges()
+}
@@ -0,0 +1,120 @@
+package slotmachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// LevelInfo describes one level of the bucket hierarchy.
+type LevelInfo struct {
+	Buckets     int     `json:"buckets"`
+	FullBuckets int     `json:"fullBuckets"`
+	FillRatio   float64 `json:"fillRatio"`
+}
+
+// LayoutInfo is a point-in-time report of a slot machine's structure and occupancy.
+// Levels are ordered from the root (a single bucket) down to the leaf level.
+type LayoutInfo struct {
+	SliceSize    int         `json:"sliceSize"`
+	Boundaries   Boundaries  `json:"boundaries"` // Spans every range
+	Ranges       RangeSet    `json:"ranges"`
+	BucketSize   uint8       `json:"bucketSize"`
+	Levels       []LevelInfo `json:"levels"`
+	BucketMemory uintptr     `json:"bucketMemory"` // Bytes used by the bucket levels
+	Usable       uint        `json:"usable"`
+	Available    uint        `json:"available"`
+	FillRatio    float64     `json:"fillRatio"`
+}
+
+func (s *SlotMachineStruct[T, V]) layout() LayoutInfo {
+	info := LayoutInfo{
+		SliceSize:  len(*s.slice),
+		Boundaries: s.boundaries,
+		Ranges:     s.ranges,
+		BucketSize: s.bucketSize,
+		Usable:     s.usable(),
+		Available:  s.available,
+	}
+	for _, level := range *s.bucketLevels {
+		li := LevelInfo{Buckets: len(level)}
+		for _, bucket := range level {
+			if bucket == s.full {
+				li.FullBuckets++
+			}
+		}
+		li.FillRatio = float64(li.FullBuckets) / float64(li.Buckets)
+		info.Levels = append(info.Levels, li)
+		info.BucketMemory += uintptr(len(level)) * unsafe.Sizeof(level[0])
+	}
+	if info.Usable > 0 {
+		info.FillRatio = float64(info.Usable-info.Available) / float64(info.Usable)
+	}
+	return info
+}
+
+// WriteText renders the layout in the same human-readable form as DumpLayout.
+func (l LayoutInfo) WriteText(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "Slice size: %d (Usable slots: %d - %d)\n", l.SliceSize, l.Boundaries.Lower, l.Boundaries.Upper); err != nil {
+		return err
+	}
+	if len(l.Ranges.Ranges) > 1 || len(l.Ranges.Exclude) > 0 {
+		if _, err := fmt.Fprintf(w, "Usable ranges: %s\n", l.Ranges); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "Bucket size: %d\n", l.BucketSize); err != nil {
+		return err
+	}
+	// Widest level first, as DumpLayout always did
+	for i := len(l.Levels) - 1; i >= 0; i-- {
+		level := l.Levels[i]
+		if _, err := fmt.Fprintf(w, "Buckets per level: %d (full: %d, %.2f%%)\n", level.Buckets, level.FullBuckets, level.FillRatio*100); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "Bucket memory: %d bytes\n", l.BucketMemory); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Available: %d/%d (%.2f%% full)\n", l.Available, l.Usable, l.FillRatio*100)
+	return err
+}
+
+// WriteJSON renders the layout as a single JSON document.
+func (l LayoutInfo) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(l)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Layout() LayoutInfo {
+	return s.st.layout()
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) DumpLayoutTo(w io.Writer) error {
+	return s.Layout().WriteText(w)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Layout() LayoutInfo {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.layout()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) DumpLayoutTo(w io.Writer) error {
+	return s.Layout().WriteText(w)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Layout() LayoutInfo {
+	var info LayoutInfo
+	s.call(func() {
+		info = s.st.layout()
+	})
+	return info
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) DumpLayoutTo(w io.Writer) error {
+	return s.Layout().WriteText(w)
+}
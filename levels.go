@@ -0,0 +1,72 @@
+package slotmachine
+
+import (
+	"math/bits"
+	"unsafe"
+
+	"golang.org/x/exp/constraints"
+)
+
+// buildLevels returns the bucket hierarchy for size slots, root level first.
+// Every level holds ceil(width/bucketSize) buckets; when the level below does not fill the last
+// bucket, its missing bits are marked as taken so that they are never handed out.
+func buildLevels(size int, bucketSize uint8, full uint64) [][]uint64 {
+	var levels [][]uint64
+	width := size
+	for {
+		bucketCount := (width + int(bucketSize) - 1) / int(bucketSize)
+		buckets := make([]uint64, bucketCount)
+		if pad := bucketCount*int(bucketSize) - width; pad > 0 {
+			buckets[bucketCount-1] = full &^ (uint64(1)<<(int(bucketSize)-pad) - 1)
+		}
+		levels = append([][]uint64{buckets}, levels...)
+		if bucketCount == 1 {
+			break
+		}
+		width = bucketCount
+	}
+	return levels
+}
+
+// maxIndex returns the largest slot index T can hold
+func maxIndex[T constraints.Integer]() uint64 {
+	var zero T
+	width := unsafe.Sizeof(zero) * 8
+	if zero-1 < 0 {
+		return 1<<(width-1) - 1
+	}
+	return 1<<width - 1
+}
+
+// levelShift returns log2 of the number of slots covered by a single bit at the given level
+func levelShift(levelCount int, levelidx int, bucketSize uint8) int {
+	return bits.TrailingZeros8(bucketSize) * (levelCount - 1 - levelidx)
+}
+
+// locate returns the bucket holding a slot at the given level, and the bit standing for it
+func (s *SlotMachineStruct[T, V]) locate(levelidx int, slotidx int) (int, int) {
+	shift := levelShift(len(*s.bucketLevels), levelidx, s.bucketSize)
+	return slotidx >> (shift + bits.TrailingZeros8(s.bucketSize)), (slotidx >> shift) & (int(s.bucketSize) - 1)
+}
+
+// span returns the number of slots covered by a bucket at the given level
+func (s *SlotMachineStruct[T, V]) span(levelidx int) int {
+	return int(s.bucketSize) << levelShift(len(*s.bucketLevels), levelidx, s.bucketSize)
+}
+
+// fillParents sets the parent bit of every full bucket, from the leaf level up.
+// Parent levels must only hold their padding bits when it is called.
+func fillParents(levels [][]uint64, bucketSize uint8, full uint64) {
+	for levelidx := len(levels) - 2; levelidx >= 0; levelidx-- {
+		for child, word := range levels[levelidx+1] {
+			if word == full {
+				levels[levelidx][child/int(bucketSize)] |= 1 << (child % int(bucketSize))
+			}
+		}
+	}
+}
+
+// recount recomputes the number of available slots, from the bitmaps
+func (s *SlotMachineStruct[T, V]) recount() {
+	s.available = uint(s.freeBefore(len(*s.slice)))
+}
@@ -1,6 +1,14 @@
 package slotmachine
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
@@ -13,12 +21,11 @@ type SMTester[T constraints.Integer, V constraints.Integer] struct {
 }
 
 func (s *SMTester[T, V]) Exercise(t *testing.T, workSlice *[]V, sliceSize int, bucketSize int, boundaries *Boundaries) {
-	var compareto []int
-	if boundaries == nil {
-		compareto = []int{32751, 32750, 4767, 4767, 31768, 31763}
-	} else {
-		compareto = []int{49984, 49983, 22000, 22000, 49001, 48996}
+	usable := sliceSize
+	if boundaries != nil {
+		usable = boundaries.Upper - boundaries.Lower + 1
 	}
+	compareto := []int{usable - 17, usable - 18, usable - 28001, usable - 28001, usable - 1000, usable - 1005}
 	var added T
 	var available uint
 	sm, err := New[T, V](
@@ -28,7 +35,7 @@ func (s *SMTester[T, V]) Exercise(t *testing.T, workSlice *[]V, sliceSize int, b
 		uint8(bucketSize),
 		boundaries)
 	if err != nil {
-		if bucketSize != 14 && len(*workSlice) != 60000 {
+		if bucketSize != 14 {
 			t.Errorf("failed to create slot machine: %s", err)
 		}
 		return
@@ -37,10 +44,6 @@ func (s *SMTester[T, V]) Exercise(t *testing.T, workSlice *[]V, sliceSize int, b
 		t.Error("a non-power of 2 bucket size should  have failed")
 		return
 	}
-	if len(*workSlice) == 60000 {
-		t.Error("a non-power of 2 slice size should  have failed")
-		return
-	}
 	for i := 0; i < 16; i++ {
 		available, _ = sm.Set(T(i), V(1))
 	}
@@ -169,11 +172,17 @@ func TestBucketSizeIs14(t *testing.T) {
 }
 
 func TestBucketSizeIs16(t *testing.T) {
-	t.Log("Testing a bucket size of 16")
+	t.Log("Testing a bucket size of 16, with a slice size that is not a power of 2")
 	tester := SMTester[uint32, uint16]{}
 	tester.DefaultTest(t, 60000, 16, nil)
 }
 
+func TestBucketSizeIs16Uneven(t *testing.T) {
+	t.Log("Testing a bucket size of 16, with a slice size that is not a multiple of it")
+	tester := SMTester[uint32, uint16]{}
+	tester.DefaultTest(t, 60003, 16, nil)
+}
+
 func TestBucketSizeIs16Boundaries(t *testing.T) {
 	t.Log("Testing a bucket size of 16")
 	tester := SMTester[uint32, uint16]{}
@@ -294,3 +303,1269 @@ func TestBucketSizeIs8Pretend(t *testing.T) {
 	}
 	t.Logf("Time elapsed: %s (also, arbitrary output: %d)", time.Since(start), whatevs)
 }
+
+func TestLayout(t *testing.T) {
+	t.Log("Testing the layout report and its renderers")
+
+	workSlice := make([]uint16, 4096)
+	sm, err := New[uint16, uint16](
+		SyncConcurrency,
+		&workSlice,
+		0,
+		uint8(16),
+		&Boundaries{0, 4000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 64; i++ {
+		sm.Set(uint16(i), 1)
+	}
+
+	info := sm.Layout()
+	if len(info.Levels) != 3 {
+		t.Fatalf("expected 3 levels, got %d", len(info.Levels))
+	}
+	for i, buckets := range []int{1, 16, 256} {
+		if info.Levels[i].Buckets != buckets {
+			t.Errorf("level %d should have %d buckets, got %d", i, buckets, info.Levels[i].Buckets)
+		}
+	}
+	// 4 booked buckets, and the 5 buckets above the upper boundary, which are masked
+	if info.Levels[2].FullBuckets != 9 {
+		t.Error("leaf level should have 9 full buckets", info.Levels[2].FullBuckets)
+	}
+	if info.BucketMemory != (1+16+256)*8 {
+		t.Error("unexpected bucket memory", info.BucketMemory)
+	}
+	if info.Usable != 4001 || info.Available != 4001-64 {
+		t.Error("unexpected usable/available", info.Usable, info.Available)
+	}
+
+	var text strings.Builder
+	if err := sm.DumpLayoutTo(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text.String(), "Slice size: 4096 (Usable slots: 0 - 4000)\nBucket size: 16\nBuckets per level: 256 (full: 9,") {
+		t.Error("unexpected text layout", text.String())
+	}
+
+	var js strings.Builder
+	if err := info.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var decoded LayoutInfo
+	if err := json.Unmarshal([]byte(js.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Available != info.Available || len(decoded.Levels) != len(info.Levels) || decoded.Boundaries != info.Boundaries {
+		t.Error("JSON layout does not round-trip", js.String())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	t.Log("Testing debug events sent to a structured logger")
+
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	workSlice := make([]uint16, 64)
+	sm, err := New[uint16, uint16](
+		NoConcurrency,
+		&workSlice,
+		0,
+		uint8(8),
+		&Boundaries{0, 15},
+		WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		sm.BookAndSet(1)
+	}
+	sm.Set(20, 1)
+	sm.Unset(3)
+	for _, msg := range []string{"bucket full", "search found leaf bucket", "set out of bounds", "parent bucket released"} {
+		if !strings.Contains(out.String(), "msg=\""+msg+"\"") {
+			t.Errorf("missing %q event in log output", msg)
+		}
+	}
+
+	out.Reset()
+	quiet := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	sm, _ = New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), nil, WithLogger(quiet))
+	sm.BookAndSet(1)
+	if out.Len() != 0 {
+		t.Error("nothing should be logged above debug level", out.String())
+	}
+}
+
+type recordingMetrics struct {
+	m          sync.Mutex
+	operations map[TransactionType]int
+	failures   int
+	available  uint
+	searches   int
+	waits      int
+}
+
+func (r *recordingMetrics) Operation(op TransactionType, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.operations[op]++
+	if err != nil {
+		r.failures++
+	}
+}
+
+func (r *recordingMetrics) Available(available uint) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.available = available
+}
+
+func (r *recordingMetrics) SearchDepth(depth int) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.searches++
+}
+
+func (r *recordingMetrics) Wait(op TransactionType, d time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.waits++
+}
+
+func TestMetrics(t *testing.T) {
+	t.Log("Testing that every operation reaches the metrics hooks, in every concurrency model")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		rec := &recordingMetrics{operations: map[TransactionType]int{}}
+		workSlice := make([]uint16, 64)
+		sm, err := New[uint16, uint16](cmodel, &workSlice, 0, uint8(8), &Boundaries{0, 31}, WithMetrics(rec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm.BookAndSetBatch(4, 1)
+		sm.Set(10, 1)
+		sm.Set(40, 1)
+		sm.Unset(0)
+
+		if rec.operations[TransactionBookAndSet] != 4 || rec.operations[TransactionSet] != 2 || rec.operations[TransactionUnset] != 1 {
+			t.Errorf("model %d: unexpected operation counts %v", cmodel, rec.operations)
+		}
+		if rec.failures != 1 || rec.available != 28 || rec.searches != 4 {
+			t.Errorf("model %d: failures=%d available=%d searches=%d", cmodel, rec.failures, rec.available, rec.searches)
+		}
+		expectedWaits := map[ConcurrencyModel]int{NoConcurrency: 0, SyncConcurrency: 4, ChannelConcurrency: 7}[cmodel]
+		if rec.waits != expectedWaits {
+			t.Errorf("model %d: expected %d waits, got %d", cmodel, expectedWaits, rec.waits)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	t.Log("Testing event subscriptions, in every concurrency model")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]uint16, 64)
+		sm, err := New[uint16, uint16](cmodel, &workSlice, 0, uint8(8), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, cancel := sm.Subscribe(16)
+		sm.Set(5, 50)
+		sm.Set(5, 51)
+		sm.BookAndSet(7)
+		sm.Unset(5)
+		sm.Unset(6)
+		sm.Set(100, 1)
+
+		expected := []Event[uint16, uint16]{
+			{Op: TransactionSet, Slot: 5, Old: 0, New: 50},
+			{Op: TransactionSet, Slot: 5, Old: 50, New: 51},
+			{Op: TransactionBookAndSet, Slot: 0, Old: 0, New: 7},
+			{Op: TransactionUnset, Slot: 5, Old: 51, New: 0},
+		}
+		for _, want := range expected {
+			got := <-events
+			if got.Time.IsZero() {
+				t.Errorf("model %d: event has no timestamp", cmodel)
+			}
+			got.Time = time.Time{}
+			if got != want {
+				t.Errorf("model %d: expected %+v, got %+v", cmodel, want, got)
+			}
+		}
+		cancel()
+		if _, ok := <-events; ok {
+			t.Errorf("model %d: channel should be closed after cancel", cmodel)
+		}
+		cancel()
+	}
+
+	// Indices too large for an int are refused before their value is read
+	wide := make([]int, 64)
+	sm, _ := New[uint64, int](NoConcurrency, &wide, 0, uint8(8), nil)
+	events, cancel := sm.Subscribe(4)
+	defer cancel()
+	if _, err := sm.Set(math.MaxUint64, 1); err == nil {
+		t.Error("setting slot MaxUint64 should fail")
+	}
+	if _, err := sm.Unset(1 << 63); err == nil {
+		t.Error("unsetting slot 1 << 63 should fail")
+	}
+	if len(events) != 0 {
+		t.Error("failed operations should not produce events")
+	}
+}
+
+func TestSubscribePolicies(t *testing.T) {
+	t.Log("Testing slow subscribers with the drop and block policies")
+
+	workSlice := make([]uint16, 64)
+	sm, _ := New[uint16, uint16](SyncConcurrency, &workSlice, 0, uint8(8), nil)
+	events, cancel := sm.Subscribe(2)
+	for i := 0; i < 10; i++ {
+		sm.BookAndSet(1)
+	}
+	if len(events) != 2 {
+		t.Error("a full subscriber should have had events dropped", len(events))
+	}
+	cancel()
+
+	sm, _ = New[uint16, uint16](SyncConcurrency, &workSlice, 0, uint8(8), nil, WithSubscriberPolicy(BlockOnEvents))
+	events, cancel = sm.Subscribe(0)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			sm.BookAndSet(1)
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		if event := <-events; event.Slot != uint16(i) {
+			t.Errorf("expected slot %d, got %d", i, event.Slot)
+		}
+	}
+	<-done
+
+	// A subscriber cancelled while an operation is blocked on it must release the operation
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, _, err := sm.BookAndSet(1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	t.Log("Testing low watermark and exhaustion callbacks, with hysteresis")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]uint16, 128)
+		sm, err := New[uint16, uint16](cmodel, &workSlice, 0, uint8(8), &Boundaries{0, 99}, WithHysteresis(AbsoluteThreshold(5)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var crossings []bool
+		var exhausted, recovered int
+		sm.OnLowWatermark(PercentThreshold(10), func(low bool, available uint) {
+			crossings = append(crossings, low)
+		})
+		sm.OnExhausted(func() { exhausted++ })
+		sm.OnRecovered(func() { recovered++ })
+
+		for i := 0; i < 90; i++ {
+			sm.BookAndSet(1)
+		}
+		if len(crossings) != 1 || !crossings[0] {
+			t.Errorf("model %d: should have crossed the low watermark once, got %v", cmodel, crossings)
+		}
+		// Hovering around the watermark, within the hysteresis margin
+		for i := 0; i < 3; i++ {
+			sm.Unset(0)
+			sm.Unset(1)
+			sm.Set(0, 1)
+			sm.Set(1, 1)
+		}
+		if len(crossings) != 1 {
+			t.Errorf("model %d: hysteresis should prevent flapping, got %v", cmodel, crossings)
+		}
+		for i := 0; i < 6; i++ {
+			sm.Unset(uint16(i))
+		}
+		if len(crossings) != 2 || crossings[1] {
+			t.Errorf("model %d: should have recovered above the watermark, got %v", cmodel, crossings)
+		}
+
+		for i := 0; i < 100; i++ {
+			sm.Set(uint16(i), 1)
+		}
+		sm.BookAndSet(1)
+		if exhausted != 1 || recovered != 0 {
+			t.Errorf("model %d: exhausted=%d recovered=%d", cmodel, exhausted, recovered)
+		}
+		for i := 0; i < 6; i++ {
+			sm.Unset(uint16(i))
+		}
+		if exhausted != 1 || recovered != 1 {
+			t.Errorf("model %d: exhausted=%d recovered=%d", cmodel, exhausted, recovered)
+		}
+	}
+}
+
+func TestIterators(t *testing.T) {
+	t.Log("Testing iterators over booked and free slots against a plain map")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		for _, bucketSize := range []int{2, 8, 16} {
+			workSlice := make([]uint32, 32768)
+			boundaries := &Boundaries{100, 30000}
+			sm, err := New[uint32, uint32](cmodel, &workSlice, 0, uint8(bucketSize), boundaries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			booked := map[uint32]uint32{}
+			rnd := rand.New(rand.NewSource(int64(bucketSize)))
+			for i := 0; i < 20000; i++ {
+				slot := uint32(boundaries.Lower + rnd.Intn(boundaries.Upper-boundaries.Lower+1))
+				if rnd.Intn(3) == 0 {
+					sm.Unset(slot)
+					delete(booked, slot)
+				} else {
+					sm.Set(slot, slot*2)
+					booked[slot] = slot * 2
+				}
+			}
+			// A fully booked region, to exercise parent bits
+			for slot := uint32(4096); slot < 8192; slot++ {
+				sm.Set(slot, slot*2)
+				booked[slot] = slot * 2
+			}
+
+			all := sm.All()
+			sm.Set(0, 1) // Out of bounds, and after the snapshot anyway
+			count := 0
+			for slot, value := range all {
+				if booked[slot] != value || value == 0 {
+					t.Fatalf("model %d, bucket %d: unexpected slot %d = %d", cmodel, bucketSize, slot, value)
+				}
+				count++
+			}
+			if count != len(booked) {
+				t.Errorf("model %d, bucket %d: All yielded %d slots, expected %d", cmodel, bucketSize, count, len(booked))
+			}
+
+			previous, count := uint32(0), 0
+			for slot := range sm.Booked() {
+				if _, ok := booked[slot]; !ok || (count > 0 && slot <= previous) {
+					t.Fatalf("model %d, bucket %d: unexpected booked slot %d", cmodel, bucketSize, slot)
+				}
+				previous = slot
+				count++
+			}
+			if count != len(booked) {
+				t.Errorf("model %d, bucket %d: Booked yielded %d slots, expected %d", cmodel, bucketSize, count, len(booked))
+			}
+
+			var free []uint32
+			for slot := range sm.Free() {
+				if _, ok := booked[slot]; ok {
+					t.Fatalf("model %d, bucket %d: slot %d is booked", cmodel, bucketSize, slot)
+				}
+				free = append(free, slot)
+			}
+			if len(free)+len(booked) != boundaries.Upper-boundaries.Lower+1 {
+				t.Errorf("model %d, bucket %d: Free yielded %d slots", cmodel, bucketSize, len(free))
+			}
+
+			var fromRanges []uint32
+			last := int64(-2)
+			for first, end := range sm.FreeRanges() {
+				if int64(first) <= last+1 {
+					t.Fatalf("model %d, bucket %d: range %d-%d is not separate from previous one", cmodel, bucketSize, first, end)
+				}
+				for slot := first; slot <= end; slot++ {
+					fromRanges = append(fromRanges, slot)
+				}
+				last = int64(end)
+			}
+			if !slices.Equal(free, fromRanges) {
+				t.Errorf("model %d, bucket %d: FreeRanges does not match Free", cmodel, bucketSize)
+			}
+
+			for range sm.Booked() {
+				break
+			}
+			for range sm.FreeRanges() {
+				break
+			}
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Log("Testing predicate search over booked slots, in every concurrency model")
+
+	type record struct {
+		service string
+		up      bool
+	}
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]record, 1024)
+		sm, err := New[uint16, record](cmodel, &workSlice, record{}, uint8(8), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm.Set(10, record{"db", true})
+		sm.Set(300, record{"web", false})
+		sm.Set(700, record{"web", true})
+		sm.Set(900, record{"cache", true})
+		sm.Unset(900)
+
+		visited := 0
+		slot, ok := sm.Find(func(slot uint16, r record) bool {
+			visited++
+			return r.service == "web" && r.up
+		})
+		if !ok || slot != 700 {
+			t.Errorf("model %d: expected to find slot 700, got %d (%t)", cmodel, slot, ok)
+		}
+		if visited != 3 {
+			t.Errorf("model %d: Find should only visit booked slots, visited %d", cmodel, visited)
+		}
+		if _, ok := sm.Find(func(slot uint16, r record) bool { return r.service == "cache" }); ok {
+			t.Errorf("model %d: released slots should not be found", cmodel)
+		}
+		all := sm.FindAll(func(slot uint16, r record) bool { return r.service == "web" })
+		if !slices.Equal(all, []uint16{300, 700}) {
+			t.Errorf("model %d: unexpected FindAll result %v", cmodel, all)
+		}
+	}
+}
+
+func TestArbitrarySizes(t *testing.T) {
+	t.Log("Testing that every slot of an arbitrarily sized slice can be booked, and no more")
+
+	for _, size := range []int{1, 3, 7, 8, 9, 100, 1000, 4097} {
+		for _, bucketSize := range []int{2, 8, 16} {
+			workSlice := make([]uint16, size)
+			sm, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(bucketSize), nil)
+			if err != nil {
+				t.Fatalf("size %d, bucket %d: %s", size, bucketSize, err)
+			}
+			for i := 0; i < size; i++ {
+				slot, _, err := sm.BookAndSet(1)
+				if err != nil || int(slot) != i {
+					t.Fatalf("size %d, bucket %d: expected slot %d, got %d (%v)", size, bucketSize, i, slot, err)
+				}
+			}
+			if _, available, err := sm.BookAndSet(1); err == nil || available != 0 {
+				t.Errorf("size %d, bucket %d: a full machine should not book (available=%d)", size, bucketSize, available)
+			}
+			sm.Unset(uint16(size - 1))
+			if slot, _, err := sm.BookAndSet(1); err != nil || int(slot) != size-1 {
+				t.Errorf("size %d, bucket %d: expected to rebook the last slot, got %d (%v)", size, bucketSize, slot, err)
+			}
+		}
+	}
+
+	workSlice := make([]uint16, 0)
+	if _, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), nil); err == nil {
+		t.Error("an empty slice should be rejected")
+	}
+}
+
+func TestValidation(t *testing.T) {
+	t.Log("Testing bucket size and index type validation, and 64-bit buckets")
+
+	small := make([]uint16, 300)
+	if _, err := New[uint8, uint16](NoConcurrency, &small, 0, uint8(8), nil); err == nil {
+		t.Error("300 slots cannot be indexed with uint8")
+	}
+	if _, err := New[int8, uint16](NoConcurrency, &small, 0, uint8(8), nil); err == nil {
+		t.Error("300 slots cannot be indexed with int8")
+	}
+	fits := make([]uint16, 256)
+	if _, err := New[uint8, uint16](NoConcurrency, &fits, 0, uint8(8), nil); err != nil {
+		t.Error("256 slots can be indexed with uint8", err)
+	}
+	for _, bucketSize := range []int{0, 1, 3, 128} {
+		if _, err := New[uint16, uint16](NoConcurrency, &small, 0, uint8(bucketSize), nil); err == nil {
+			t.Errorf("bucket size %d should be rejected", bucketSize)
+		}
+	}
+	for _, boundaries := range []Boundaries{{-1, 10}, {0, 300}, {20, 10}} {
+		if _, err := New[uint16, uint16](NoConcurrency, &small, 0, uint8(8), &boundaries); err == nil {
+			t.Errorf("boundaries %v should be rejected", boundaries)
+		}
+	}
+
+	// Indices too large for an int are out of bounds, not negative
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		wide := make([]int, 100)
+		sm, err := New[uint64, int](cmodel, &wide, 0, uint8(8), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, slot := range []uint64{100, 1 << 63, math.MaxUint64} {
+			if _, err := sm.Set(slot, 1); err == nil {
+				t.Errorf("model %d: setting slot %d should fail", cmodel, slot)
+			}
+			if _, err := sm.Unset(slot); err == nil {
+				t.Errorf("model %d: unsetting slot %d should fail", cmodel, slot)
+			}
+			if _, booked := sm.Get(slot); booked {
+				t.Errorf("model %d: slot %d should not be booked", cmodel, slot)
+			}
+		}
+	}
+	bm, _ := NewBitmap[uint64](NoConcurrency, 100, uint8(8), nil)
+	if bm.IsFree(1 << 63) {
+		t.Error("slot 1 << 63 should not be free")
+	}
+
+	// Buckets wider than the index type
+	for _, bucketSize := range []int{32, 64} {
+		workSlice := make([]uint16, 10000)
+		sm, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(bucketSize), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i++ {
+			slot, _, err := sm.BookAndSet(1)
+			if err != nil || int(slot) != i {
+				t.Fatalf("bucket %d: expected slot %d, got %d (%v)", bucketSize, i, slot, err)
+			}
+		}
+		if _, _, err := sm.BookAndSet(1); err == nil {
+			t.Errorf("bucket %d: a full machine should not book", bucketSize)
+		}
+		sm.Unset(4242)
+		if slot, _, _ := sm.BookAndSet(1); slot != 4242 {
+			t.Errorf("bucket %d: expected to rebook 4242, got %d", bucketSize, slot)
+		}
+	}
+}
+
+// Only the last slot is free: the worst case for a search that scans buckets from the start
+func benchmarkBookAndSet(b *testing.B, size int) {
+	workSlice := make([]uint8, size)
+	sm, err := New[uint32, uint8](NoConcurrency, &workSlice, 0, uint8(16), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < size-1; i++ {
+		sm.Set(uint32(i), 1)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		slot, _, err := sm.BookAndSet(1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		sm.Unset(slot)
+	}
+}
+
+func BenchmarkBookAndSet64K(b *testing.B) {
+	benchmarkBookAndSet(b, 1<<16)
+}
+
+func BenchmarkBookAndSet1M(b *testing.B) {
+	benchmarkBookAndSet(b, 1<<20)
+}
+
+func BenchmarkBookAndSet16M(b *testing.B) {
+	benchmarkBookAndSet(b, 1<<24)
+}
+
+// checkLevels verifies that every parent bit is set exactly when the bucket it stands for is full,
+// or does not exist, and that leaf bits match the expected bookings
+func checkLevels[T constraints.Integer, V any](st *SlotMachineStruct[T, V], booked []bool) error {
+	levels := *st.bucketLevels
+	bs := int(st.bucketSize)
+	leaf := levels[len(levels)-1]
+	for slot := 0; slot < len(leaf)*bs; slot++ {
+		expected := slot >= len(booked) || booked[slot]
+		if actual := leaf[slot/bs]&(1<<(slot%bs)) != 0; actual != expected {
+			return fmt.Errorf("leaf bit for slot %d is %t, expected %t", slot, actual, expected)
+		}
+	}
+	for levelidx := len(levels) - 2; levelidx >= 0; levelidx-- {
+		children := levels[levelidx+1]
+		for bucket, word := range levels[levelidx] {
+			for offset := 0; offset < bs; offset++ {
+				child := bucket*bs + offset
+				expected := child >= len(children) || children[child] == st.full
+				if actual := word&(1<<offset) != 0; actual != expected {
+					return fmt.Errorf("level %d, bucket %d, bit %d is %t, expected %t", levelidx, bucket, offset, actual, expected)
+				}
+			}
+			if st.counts == nil {
+				continue
+			}
+			var free uint64
+			for slot := bucket * st.span(levelidx); slot < (bucket+1)*st.span(levelidx) && slot < len(booked); slot++ {
+				if !booked[slot] {
+					free++
+				}
+			}
+			if st.counts[levelidx][bucket] != free {
+				return fmt.Errorf("level %d, bucket %d counts %d free slots, expected %d", levelidx, bucket, st.counts[levelidx][bucket], free)
+			}
+		}
+	}
+	return nil
+}
+
+func TestRandomizedHierarchy(t *testing.T) {
+	t.Log("Testing that set/unset propagation keeps the hierarchy consistent with the search descent")
+
+	for _, size := range []int{5, 1000, 4096, 60003} {
+		for _, bucketSize := range []int{2, 8, 64} {
+			workSlice := make([]uint32, size)
+			sm, err := New[uint32, uint32](NoConcurrency, &workSlice, 0, uint8(bucketSize), nil, WithCounts())
+			if err != nil {
+				t.Fatal(err)
+			}
+			st := &sm.(*NoConcurrencySlotMachine[uint32, uint32]).st
+			booked := make([]bool, size)
+			count := 0
+			rnd := rand.New(rand.NewSource(int64(size * bucketSize)))
+			for i := 0; i < 20000; i++ {
+				// Bias towards booking, so that buckets regularly fill up and empty again
+				switch op := rnd.Intn(10); {
+				case op < 4:
+					slot := rnd.Intn(size)
+					sm.Set(uint32(slot), 1)
+					if !booked[slot] {
+						booked[slot] = true
+						count++
+					}
+				case op < 7:
+					slot := rnd.Intn(size)
+					sm.Unset(uint32(slot))
+					if booked[slot] {
+						booked[slot] = false
+						count--
+					}
+				default:
+					lowest := slices.Index(booked, false)
+					slot, _, err := sm.BookAndSet(1)
+					if lowest < 0 {
+						if err == nil {
+							t.Fatalf("size %d, bucket %d: booked %d in a full machine", size, bucketSize, slot)
+						}
+						continue
+					}
+					if err != nil || int(slot) != lowest {
+						t.Fatalf("size %d, bucket %d: expected lowest free slot %d, got %d (%v)", size, bucketSize, lowest, slot, err)
+					}
+					booked[slot] = true
+					count++
+				}
+				if st.available != uint(size-count) {
+					t.Fatalf("size %d, bucket %d: available is %d, expected %d", size, bucketSize, st.available, size-count)
+				}
+				if i%97 == 0 {
+					if err := checkLevels(st, booked); err != nil {
+						t.Fatalf("size %d, bucket %d, after %d operations: %s", size, bucketSize, i, err)
+					}
+				}
+			}
+			if err := checkLevels(st, booked); err != nil {
+				t.Fatalf("size %d, bucket %d: %s", size, bucketSize, err)
+			}
+		}
+	}
+}
+
+func TestRankSelect(t *testing.T) {
+	t.Log("Testing CountFree, Rank and Select, with and without per-bucket counts")
+
+	for _, counted := range []bool{false, true} {
+		for _, size := range []int{7, 64, 1000, 4096} {
+			for _, bucketSize := range []int{2, 8, 64} {
+				var opts []Option
+				if counted {
+					opts = append(opts, WithCounts())
+				}
+				workSlice := make([]uint16, size)
+				boundaries := &Boundaries{size / 10, size - size/10 - 1}
+				sm, err := New[uint16, uint16](SyncConcurrency, &workSlice, 0, uint8(bucketSize), boundaries, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				booked := make([]bool, size)
+				rnd := rand.New(rand.NewSource(int64(size + bucketSize)))
+				for i := 0; i < size*2; i++ {
+					slot := boundaries.Lower + rnd.Intn(boundaries.Upper-boundaries.Lower+1)
+					if rnd.Intn(3) == 0 {
+						sm.Unset(uint16(slot))
+						booked[slot] = false
+					} else {
+						sm.Set(uint16(slot), 1)
+						booked[slot] = true
+					}
+				}
+
+				var free []int
+				for slot := boundaries.Lower; slot <= boundaries.Upper; slot++ {
+					if !booked[slot] {
+						free = append(free, slot)
+					}
+				}
+				for k, slot := range free {
+					if got, err := sm.Select(uint(k)); err != nil || int(got) != slot {
+						t.Fatalf("counted=%t size %d bucket %d: Select(%d) = %d (%v), expected %d", counted, size, bucketSize, k, got, err, slot)
+					}
+				}
+				if _, err := sm.Select(uint(len(free))); err == nil {
+					t.Errorf("counted=%t size %d bucket %d: Select past the last free slot should fail", counted, size, bucketSize)
+				}
+
+				rank := 0
+				for slot := 0; slot <= size; slot++ {
+					if got, err := sm.Rank(uint16(slot)); err != nil || int(got) != rank {
+						t.Fatalf("counted=%t size %d bucket %d: Rank(%d) = %d (%v), expected %d", counted, size, bucketSize, slot, got, err, rank)
+					}
+					if slot < size && booked[slot] {
+						rank++
+					}
+				}
+
+				for i := 0; i < 50; i++ {
+					lo, hi := rnd.Intn(size), rnd.Intn(size)
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					expected := 0
+					for _, slot := range free {
+						if slot >= lo && slot <= hi {
+							expected++
+						}
+					}
+					if got, err := sm.CountFree(uint16(lo), uint16(hi)); err != nil || int(got) != expected {
+						t.Fatalf("counted=%t size %d bucket %d: CountFree(%d, %d) = %d (%v), expected %d", counted, size, bucketSize, lo, hi, got, err, expected)
+					}
+				}
+			}
+		}
+	}
+
+	// Signed index types, and ranges reaching past either end of the slice
+	for _, counted := range []bool{false, true} {
+		var opts []Option
+		if counted {
+			opts = append(opts, WithCounts())
+		}
+		workSlice := make([]int, 100)
+		sm, err := New[int, int](SyncConcurrency, &workSlice, 0, uint8(8), nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sm.Set(10, 1)
+		for _, c := range []struct{ lo, hi, expected int }{{-5, -3, 0}, {-5, 20, 20}, {90, 1000, 10}, {100, 200, 0}, {-1000, 1000, 99}} {
+			if got, err := sm.CountFree(c.lo, c.hi); err != nil || int(got) != c.expected {
+				t.Errorf("counted=%t: CountFree(%d, %d) = %d (%v), expected %d", counted, c.lo, c.hi, got, err, c.expected)
+			}
+		}
+
+		small := make([]int, 100)
+		sm8, err := New[int8, int](SyncConcurrency, &small, 0, uint8(8), nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := sm8.CountFree(-100, -50); err != nil || got != 0 {
+			t.Errorf("counted=%t: CountFree(-100, -50) = %d (%v), expected 0", counted, got, err)
+		}
+		if got, err := sm8.CountFree(-100, 127); err != nil || got != 100 {
+			t.Errorf("counted=%t: CountFree(-100, 127) = %d (%v), expected 100", counted, got, err)
+		}
+
+		wide := make([]int, 100)
+		sm64, err := New[uint64, int](SyncConcurrency, &wide, 0, uint8(8), nil, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := sm64.CountFree(0, math.MaxUint64); err != nil || got != 100 {
+			t.Errorf("counted=%t: CountFree(0, MaxUint64) = %d (%v), expected 100", counted, got, err)
+		}
+	}
+}
+
+func TestResize(t *testing.T) {
+	t.Log("Testing growing and shrinking the managed slice, in every concurrency model")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]uint32, 100)
+		sm, err := New[uint32, uint32](cmodel, &workSlice, 0, uint8(8), nil, WithCounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			sm.Set(uint32(i), uint32(i)+1)
+		}
+		if _, _, err := sm.BookAndSet(1); err == nil {
+			t.Fatalf("model %d: machine should be full", cmodel)
+		}
+
+		// Adopt a larger slice: values are copied, new slots become available
+		larger := make([]uint32, 1000)
+		if err := sm.Grow(&larger); err != nil {
+			t.Fatal(err)
+		}
+		if larger[42] != 43 {
+			t.Errorf("model %d: values should have been copied to the new slice", cmodel)
+		}
+		slot, available, err := sm.BookAndSet(7)
+		if err != nil || slot != 100 || available != 899 {
+			t.Errorf("model %d: expected to book slot 100 with 899 left, got %d, %d (%v)", cmodel, slot, available, err)
+		}
+		if sm.Layout().Levels[0].Buckets != 1 || len(sm.Layout().Levels) != 4 {
+			t.Errorf("model %d: unexpected levels after growing %+v", cmodel, sm.Layout().Levels)
+		}
+
+		// Resize in place: the caller's slice follows
+		if err := sm.Resize(5000); err != nil {
+			t.Fatal(err)
+		}
+		if len(larger) != 5000 || larger[100] != 7 {
+			t.Errorf("model %d: resizing should replace the managed slice (len %d)", cmodel, len(larger))
+		}
+		sm.Set(4999, 1)
+
+		if err := sm.Shrink(4000); err == nil {
+			t.Errorf("model %d: shrinking over a booked slot should fail", cmodel)
+		}
+		sm.Unset(4999)
+		if err := sm.Shrink(150); err != nil {
+			t.Fatal(err)
+		}
+		if err := sm.Resize(120); err != nil {
+			t.Fatal(err)
+		}
+		info := sm.Layout()
+		if info.SliceSize != 120 || info.Boundaries.Upper != 119 || info.Available != 19 {
+			t.Errorf("model %d: unexpected layout after shrinking %+v", cmodel, info)
+		}
+		if free, _ := sm.CountFree(0, 119); free != 19 {
+			t.Errorf("model %d: counts should follow resizing, got %d free", cmodel, free)
+		}
+		if err := sm.Resize(0); err == nil {
+			t.Errorf("model %d: resizing to nothing should fail", cmodel)
+		}
+		var st *SlotMachineStruct[uint32, uint32]
+		switch m := sm.(type) {
+		case *NoConcurrencySlotMachine[uint32, uint32]:
+			st = &m.st
+		case *SyncConcurrencySlotMachine[uint32, uint32]:
+			st = &m.st
+		case *ChannelConcurrencySlotMachine[uint32, uint32]:
+			continue
+		}
+		booked := make([]bool, 120)
+		for i := 0; i <= 100; i++ {
+			booked[i] = true
+		}
+		if err := checkLevels(st, booked); err != nil {
+			t.Errorf("model %d: %s", cmodel, err)
+		}
+	}
+
+	small := make([]uint16, 200)
+	sm, _ := New[uint8, uint16](NoConcurrency, &small, 0, uint8(8), nil)
+	if err := sm.Resize(300); err == nil {
+		t.Error("growing past the index type's range should fail")
+	}
+}
+
+func TestSetBoundaries(t *testing.T) {
+	t.Log("Testing runtime boundary changes, in every concurrency model")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]uint16, 200)
+		sm, err := New[uint16, uint16](cmodel, &workSlice, 0, uint8(8), &Boundaries{50, 99}, WithCounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The lower boundary is honored when booking
+		slot, available, err := sm.BookAndSet(1)
+		if err != nil || slot != 50 || available != 49 {
+			t.Errorf("model %d: expected to book slot 50 with 49 left, got %d, %d (%v)", cmodel, slot, available, err)
+		}
+		sm.Set(60, 1)
+
+		// Widening frees new slots
+		if err := sm.SetBoundaries(Boundaries{10, 149}); err != nil {
+			t.Fatal(err)
+		}
+		if info := sm.Layout(); info.Usable != 140 || info.Available != 138 {
+			t.Errorf("model %d: unexpected usable/available after widening %d/%d", cmodel, info.Available, info.Usable)
+		}
+		if slot, _, _ := sm.BookAndSet(1); slot != 10 {
+			t.Errorf("model %d: expected to book slot 10, got %d", cmodel, slot)
+		}
+		sm.Unset(10)
+
+		// Narrowing over booked slots is reported, and they are kept
+		err = sm.SetBoundaries(Boundaries{55, 149})
+		var outside *BookedOutsideError[uint16]
+		if !errors.As(err, &outside) || outside.Refused || !slices.Equal(outside.Slots, []uint16{50}) {
+			t.Errorf("model %d: expected slot 50 to be reported outside, got %v", cmodel, err)
+		}
+		if _, err := sm.Unset(50); err == nil {
+			t.Errorf("model %d: slot outside boundaries should not be unset", cmodel)
+		}
+		if _, err := sm.Set(54, 1); err == nil {
+			t.Errorf("model %d: slot outside boundaries should not be set", cmodel)
+		}
+		if free, _ := sm.CountFree(0, 199); free != 94 {
+			t.Errorf("model %d: expected 94 free slots, got %d", cmodel, free)
+		}
+		if k, _ := sm.Select(0); k != 55 {
+			t.Errorf("model %d: first free slot should be 55, got %d", cmodel, k)
+		}
+		if err := sm.Shrink(50); err == nil {
+			t.Errorf("model %d: shrinking over a booked slot outside boundaries should fail", cmodel)
+		}
+
+		// Widening again brings it back
+		if err := sm.SetBoundaries(Boundaries{0, 199}); err != nil {
+			t.Fatal(err)
+		}
+		if booked := slices.Collect(sm.Booked()); !slices.Equal(booked, []uint16{50, 60}) || workSlice[50] != 1 {
+			t.Errorf("model %d: unexpected booked slots %v", cmodel, booked)
+		}
+		if err := sm.SetBoundaries(Boundaries{0, 200}); err == nil {
+			t.Errorf("model %d: boundaries past the slice should be refused", cmodel)
+		}
+
+		var st *SlotMachineStruct[uint16, uint16]
+		switch m := sm.(type) {
+		case *NoConcurrencySlotMachine[uint16, uint16]:
+			st = &m.st
+		case *SyncConcurrencySlotMachine[uint16, uint16]:
+			st = &m.st
+		case *ChannelConcurrencySlotMachine[uint16, uint16]:
+			continue
+		}
+		booked := make([]bool, 200)
+		booked[50], booked[60] = true, true
+		if err := checkLevels(st, booked); err != nil {
+			t.Errorf("model %d: %s", cmodel, err)
+		}
+	}
+
+	workSlice := make([]uint16, 100)
+	sm, _ := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), nil, WithStrictBoundaries())
+	sm.Set(90, 1)
+	err := sm.SetBoundaries(Boundaries{0, 49})
+	var outside *BookedOutsideError[uint16]
+	if !errors.As(err, &outside) || !outside.Refused {
+		t.Errorf("strict boundaries should refuse leaving slot 90 outside, got %v", err)
+	}
+	if sm.Layout().Boundaries.Upper != 99 {
+		t.Error("refused boundaries should not be applied")
+	}
+}
+
+func TestRanges(t *testing.T) {
+	t.Log("Testing disjoint ranges and exclusions")
+
+	ranges := RangeSet{
+		Ranges:  []Boundaries{{8000, 32767}, {1024, 5999}},
+		Exclude: []int{3306, 5432, 6379, 1024},
+	}
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		workSlice := make([]uint16, 65536)
+		sm, err := New[uint16, uint16](cmodel, &workSlice, 0, uint8(64), nil, WithRanges(ranges), WithCounts())
+		if err != nil {
+			t.Fatal(err)
+		}
+		usable := uint(5999-1024+1) + uint(32767-8000+1) - 3
+		if info := sm.Layout(); info.Usable != usable || info.Available != usable || info.Boundaries != (Boundaries{1024, 32767}) {
+			t.Errorf("model %d: unexpected layout %+v", cmodel, info)
+		}
+		if slot, _, _ := sm.BookAndSet(1); slot != 1025 {
+			t.Errorf("model %d: expected to book slot 1025 first, got %d", cmodel, slot)
+		}
+		for _, slot := range []uint16{1023, 3306, 7000, 32768} {
+			if _, err := sm.Set(slot, 1); err == nil {
+				t.Errorf("model %d: slot %d should not be usable", cmodel, slot)
+			}
+		}
+		sm.Set(3305, 1)
+		sm.Set(3307, 1)
+		booked := 2
+		for {
+			slot, _, err := sm.BookAndSet(1)
+			if err != nil {
+				break
+			}
+			if slot == 3306 || slot == 5432 || slot == 6379 || slot > 5999 && slot < 8000 || slot > 32767 {
+				t.Fatalf("model %d: booked unusable slot %d", cmodel, slot)
+			}
+			booked++
+		}
+		if booked != int(usable)-1 {
+			t.Errorf("model %d: booked %d slots, expected %d", cmodel, booked, usable-1)
+		}
+		if n := len(slices.Collect(sm.Booked())); n != booked+1 {
+			t.Errorf("model %d: iterated over %d booked slots, expected %d", cmodel, n, booked+1)
+		}
+		sm.Unset(5431)
+		sm.Unset(5433)
+		sm.Unset(8000)
+		var free [][2]uint16
+		for first, last := range sm.FreeRanges() {
+			free = append(free, [2]uint16{first, last})
+		}
+		if !slices.Equal(free, [][2]uint16{{5431, 5431}, {5433, 5433}, {8000, 8000}}) {
+			t.Errorf("model %d: unexpected free ranges %v", cmodel, free)
+		}
+		if rank, _ := sm.Rank(5433); rank != 5433-1024-3-1 {
+			t.Errorf("model %d: unexpected rank %d", cmodel, rank)
+		}
+		if slot, _ := sm.Select(2); slot != 8000 {
+			t.Errorf("model %d: third free slot should be 8000, got %d", cmodel, slot)
+		}
+
+		// Dropping an exclusion at runtime makes it available
+		if err := sm.SetRanges(RangeSet{Ranges: ranges.Ranges, Exclude: []int{1024, 3306, 6379}}); err != nil {
+			t.Fatal(err)
+		}
+		if free, _ := sm.CountFree(0, 65535); free != 4 {
+			t.Errorf("model %d: expected 4 free slots, got %d", cmodel, free)
+		}
+	}
+
+	workSlice := make([]uint16, 100)
+	if _, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), &Boundaries{0, 10}, WithRanges(ranges)); err == nil {
+		t.Error("boundaries and ranges together should be refused")
+	}
+	if _, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), nil, WithRanges(ranges)); err == nil {
+		t.Error("ranges past the slice should be refused")
+	}
+	sm, err := New[uint16, uint16](NoConcurrency, &workSlice, 0, uint8(8), nil, WithRanges(RangeSet{Ranges: []Boundaries{{10, 20}, {15, 30}, {60, 99}}, Exclude: []int{99}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text strings.Builder
+	sm.DumpLayoutTo(&text)
+	if !strings.Contains(text.String(), "Usable ranges: 10 - 30, 60 - 99 (1 excluded)\n") {
+		t.Error("unexpected text layout", text.String())
+	}
+	if err := sm.Resize(200); err != nil {
+		t.Fatal(err)
+	}
+	if r := sm.Layout().Ranges; !slices.Equal(r.Ranges, []Boundaries{{10, 30}, {60, 199}}) || !slices.Equal(r.Exclude, []int{99}) {
+		t.Errorf("unexpected ranges after growing %v", r)
+	}
+	if err := sm.Shrink(25); err != nil {
+		t.Fatal(err)
+	}
+	if r := sm.Layout().Ranges; !slices.Equal(r.Ranges, []Boundaries{{10, 24}}) || len(r.Exclude) != 0 || sm.Layout().Available != 15 {
+		t.Errorf("unexpected ranges after shrinking %v", r)
+	}
+}
+
+func TestBitmap(t *testing.T) {
+	t.Log("Testing bitmap-only machines, in every concurrency model")
+
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		bm, err := NewBitmap[uint32](cmodel, 1000, uint8(32), &Boundaries{10, 999})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slot, available, err := bm.Book()
+		if err != nil || slot != 10 || available != 989 {
+			t.Errorf("model %d: expected to book slot 10 with 989 left, got %d, %d (%v)", cmodel, slot, available, err)
+		}
+		if _, err := bm.Set(500); err != nil {
+			t.Fatal(err)
+		}
+		if bm.IsFree(500) || !bm.IsFree(501) || bm.IsFree(5) || bm.IsFree(1000) {
+			t.Errorf("model %d: unexpected free state", cmodel)
+		}
+		slots, available, err := bm.BookBatch(3)
+		if err != nil || !slices.Equal(slots, []uint32{11, 12, 13}) || available != 985 {
+			t.Errorf("model %d: unexpected batch %v, %d (%v)", cmodel, slots, available, err)
+		}
+		bm.Unset(500)
+		if booked := slices.Collect(bm.Booked()); !slices.Equal(booked, []uint32{10, 11, 12, 13}) {
+			t.Errorf("model %d: unexpected booked slots %v", cmodel, booked)
+		}
+		if err := bm.Resize(2000); err != nil {
+			t.Fatal(err)
+		}
+		if info := bm.Layout(); info.SliceSize != 2000 || info.Available != 1986 {
+			t.Errorf("model %d: unexpected layout after resizing %+v", cmodel, info)
+		}
+	}
+
+	if _, err := NewBitmap[uint8](NoConcurrency, 1000, uint8(8), nil); err == nil {
+		t.Error("capacity past the index type's range should be refused")
+	}
+}
+
+func TestPool(t *testing.T) {
+	t.Log("Testing typed pools, in every concurrency model")
+
+	type service struct {
+		id    string
+		state int
+	}
+	empty := service{id: "<free>"}
+	for _, cmodel := range []ConcurrencyModel{NoConcurrency, SyncConcurrency, ChannelConcurrency} {
+		pool, err := NewPool[uint16](cmodel, 300, empty, uint8(16), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slot, _, err := pool.BookAndSet(service{id: "db", state: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, booked := pool.Get(slot); !booked || value.id != "db" {
+			t.Errorf("model %d: unexpected value %+v", cmodel, value)
+		}
+		if value, booked := pool.Get(slot + 1); booked || value != empty {
+			t.Errorf("model %d: free slots should read as empty, got %+v", cmodel, value)
+		}
+		pool.Unset(slot)
+		if value, booked := pool.Get(slot); booked || value != empty {
+			t.Errorf("model %d: released slots should read as empty, got %+v", cmodel, value)
+		}
+		if _, booked := pool.Get(300); booked {
+			t.Errorf("model %d: slots out of bounds should not be booked", cmodel)
+		}
+
+		pool.Set(299, service{id: "cache"})
+		if err := pool.Resize(600); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := pool.Get(299); value.id != "cache" {
+			t.Errorf("model %d: values should survive resizing, got %+v", cmodel, value)
+		}
+		if value, booked := pool.Get(599); booked || value != empty {
+			t.Errorf("model %d: new slots should read as empty, got %+v", cmodel, value)
+		}
+	}
+
+	// The zero value is a fine empty value
+	pool, _ := NewPool[uint8, string](NoConcurrency, 10, "", uint8(8), nil)
+	pool.Set(3, "three")
+	if found, ok := pool.Find(func(_ uint8, v string) bool { return v == "three" }); !ok || found != 3 {
+		t.Error("expected to find slot 3", found)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Log("Testing snapshots, and restoring them")
+
+	workSlice := make([]string, 500)
+	sm, err := New[uint16, string](SyncConcurrency, &workSlice, "", uint8(16), nil,
+		WithRanges(RangeSet{Ranges: []Boundaries{{10, 99}, {200, 499}}, Exclude: []int{50}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sm.BookAndSet("first")
+	sm.Set(300, "three hundred")
+
+	var buf bytes.Buffer
+	if err := sm.Snapshot().WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := ReadSnapshot[uint16, string](&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, slice, err := Restore(ChannelConcurrency, snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (*slice)[300] != "three hundred" || len(*slice) != 500 {
+		t.Error("values should be restored")
+	}
+	if restored.Layout().Available != sm.Layout().Available || restored.Layout().Usable != 389 {
+		t.Error("unexpected availability after restoring", restored.Layout().Available, restored.Layout().Usable)
+	}
+	if booked := slices.Collect(restored.Booked()); !slices.Equal(booked, []uint16{10, 300}) {
+		t.Error("unexpected booked slots", booked)
+	}
+
+	if _, err := ReadSnapshot[uint16, string](strings.NewReader("{")); err == nil {
+		t.Error("a truncated snapshot should not be read")
+	}
+	snap.Slots = append(snap.Slots, SnapshotSlot[uint16, string]{50, "excluded"})
+	if _, _, err := Restore(NoConcurrency, snap); err == nil {
+		t.Error("a snapshot booking an excluded slot should not be restored")
+	}
+}
+
+func TestStats(t *testing.T) {
+	t.Log("Testing fragmentation statistics and heatmaps")
+
+	workSlice := make([]uint16, 4096)
+	sm, err := New[uint16, uint16](SyncConcurrency, &workSlice, 0, uint8(16), nil,
+		WithRanges(RangeSet{Ranges: []Boundaries{{0, 3071}}, Exclude: []int{1000}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fill the first 256 slots, then every other slot of the next 256
+	for i := 0; i < 256; i++ {
+		sm.Set(uint16(i), 1)
+	}
+	for i := 256; i < 512; i += 2 {
+		sm.Set(uint16(i), 1)
+	}
+
+	st := sm.Stats()
+	if st.BucketSpan != 256 || len(st.Buckets) != 16 {
+		t.Fatalf("expected 16 buckets of 256 slots, got %d of %d", len(st.Buckets), st.BucketSpan)
+	}
+	// 127 single free slots, then 511 - 999 and 1001 - 3071 around the exclusion
+	if st.FreeRuns != 129 || st.LargestFreeRun != 3071-1000 || st.Available != 3072-1-384 {
+		t.Errorf("unexpected runs %d, largest %d, available %d", st.FreeRuns, st.LargestFreeRun, st.Available)
+	}
+	for i, expected := range []BucketFill{{256, 0}, {256, 128}, {256, 256}, {255, 255}, {256, 256}} {
+		if st.Buckets[i] != expected {
+			t.Errorf("bucket %d: expected %+v, got %+v", i, expected, st.Buckets[i])
+		}
+	}
+	if st.Buckets[12].Usable != 0 || st.Buckets[1].Ratio() != 0.5 {
+		t.Error("unexpected bucket fills", st.Buckets[12], st.Buckets[1].Ratio())
+	}
+
+	var heatmap strings.Builder
+	if err := st.WriteHeatmap(&heatmap, 8); err != nil {
+		t.Fatal(err)
+	}
+	if heatmap.String() != "         0 @+......\n      2048 ....    \n" {
+		t.Errorf("unexpected heatmap %q", heatmap.String())
+	}
+	var svg bytes.Buffer
+	if err := st.WriteSVG(&svg, 8, 10); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(svg.String(), `<svg xmlns="http://www.w3.org/2000/svg" width="80" height="20">`) || strings.Count(svg.String(), "<rect") != 16 {
+		t.Error("unexpected SVG", svg.String())
+	}
+	var img bytes.Buffer
+	if err := st.WritePNG(&img, 8, 4); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(img.Bytes(), []byte("\x89PNG")) {
+		t.Error("expected a PNG image")
+	}
+	if err := st.WriteHeatmap(&heatmap, 0); err == nil {
+		t.Error("a heatmap without width should be refused")
+	}
+
+	single := make([]uint16, 10)
+	sm, _ = New[uint16, uint16](NoConcurrency, &single, 0, uint8(16), nil)
+	if st := sm.Stats(); len(st.Buckets) != 1 || st.Buckets[0] != (BucketFill{10, 10}) || st.LargestFreeRun != 10 {
+		t.Errorf("unexpected single level stats %+v", st)
+	}
+}
@@ -0,0 +1,65 @@
+package slotmachine
+
+import (
+	"time"
+)
+
+// Metrics receives measurements from every slot machine operation.
+// Methods are called while the machine is held, so implementations must be cheap
+// and safe for concurrent use.
+type Metrics interface {
+	// Operation counts a completed Set, Unset or BookAndSet; err is non-nil if it failed
+	Operation(op TransactionType, err error)
+	// Available reports the number of free slots left after an operation
+	Available(available uint)
+	// SearchDepth reports how many buckets BookAndSet inspected to find a slot
+	SearchDepth(depth int)
+	// Wait reports how long an operation waited for the mutex or the transactor channel
+	Wait(op TransactionType, d time.Duration)
+}
+
+// WithMetrics reports every operation to m.
+func WithMetrics(m Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+func (t TransactionType) String() string {
+	switch t {
+	case TransactionSet:
+		return "set"
+	case TransactionUnset:
+		return "unset"
+	case TransactionBookAndSet:
+		return "book"
+	default:
+		return "unknown"
+	}
+}
+
+func (s *SlotMachineStruct[T, V]) observe(op TransactionType, err error) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.Operation(op, err)
+	s.metrics.Available(s.available)
+}
+
+// queued returns the time a transaction started waiting, or the zero time when nobody is measuring
+func (s *SlotMachineStruct[T, V]) queued() time.Time {
+	if s.metrics == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) lock(op TransactionType) {
+	if s.st.metrics == nil {
+		s.st.m.Lock()
+		return
+	}
+	start := time.Now()
+	s.st.m.Lock()
+	s.st.metrics.Wait(op, time.Since(start))
+}
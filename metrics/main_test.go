@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fusion/slotmachine"
+)
+
+func TestCollector(t *testing.T) {
+	t.Log("Testing the expvar and Prometheus exporters")
+
+	collector := New("testpool")
+	workSlice := make([]uint16, 256)
+	sm, err := slotmachine.New[uint16, uint16](
+		slotmachine.SyncConcurrency,
+		&workSlice,
+		0,
+		uint8(16),
+		&slotmachine.Boundaries{Lower: 0, Upper: 99},
+		slotmachine.WithMetrics(collector))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		sm.BookAndSet(1)
+	}
+	sm.Unset(3)
+	sm.Set(200, 1)
+
+	rec := httptest.NewRecorder()
+	collector.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`slotmachine_operations_total{machine="testpool",op="book"} 10`,
+		`slotmachine_operations_total{machine="testpool",op="unset"} 1`,
+		`slotmachine_failures_total{machine="testpool",op="set"} 1`,
+		`slotmachine_available{machine="testpool"} 91`,
+		`slotmachine_search_depth_count{machine="testpool"} 10`,
+		`slotmachine_wait_seconds_bucket{machine="testpool",le="+Inf"} 12`,
+		"# TYPE slotmachine_wait_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in exposition:\n%s", line, body)
+		}
+	}
+
+	collector.Publish()
+	var snapshot struct {
+		Operations map[string]uint64 `json:"operations"`
+		Available  uint64            `json:"available"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("testpool").String()), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Operations["book"] != 10 || snapshot.Available != 91 {
+		t.Error("unexpected expvar snapshot", snapshot)
+	}
+}
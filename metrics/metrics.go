@@ -0,0 +1,187 @@
+// Package metrics collects slot machine measurements and publishes them through
+// expvar, or as Prometheus text exposition, without any external client library.
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/fusion/slotmachine"
+)
+
+// Upper bounds of the histogram buckets; the last, implicit, bucket is +Inf
+var (
+	waitBounds  = []float64{0.000001, 0.00001, 0.0001, 0.001, 0.01, 0.1, 1}
+	depthBounds = []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 1024}
+)
+
+var ops = []slotmachine.TransactionType{
+	slotmachine.TransactionSet,
+	slotmachine.TransactionUnset,
+	slotmachine.TransactionBookAndSet,
+}
+
+type histogram struct {
+	bounds []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Uint64 // In units of scale
+	scale  float64
+}
+
+func newHistogram(bounds []float64, scale float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1), scale: scale}
+}
+
+func (h *histogram) observe(v float64) {
+	idx := sort.SearchFloat64s(h.bounds, v)
+	h.counts[idx].Add(1)
+	h.count.Add(1)
+	h.sum.Add(uint64(v / h.scale))
+}
+
+// Collector implements slotmachine.Metrics for a single machine.
+// It is safe for concurrent use and never blocks.
+type Collector struct {
+	name       string
+	operations [3]atomic.Uint64
+	failures   [3]atomic.Uint64
+	available  atomic.Uint64
+	depth      *histogram
+	wait       *histogram
+}
+
+// New returns a collector whose samples are labelled with name.
+// Pass it to slotmachine.New using slotmachine.WithMetrics.
+func New(name string) *Collector {
+	return &Collector{
+		name:  name,
+		depth: newHistogram(depthBounds, 1),
+		wait:  newHistogram(waitBounds, 1e-9),
+	}
+}
+
+func (c *Collector) Operation(op slotmachine.TransactionType, err error) {
+	if int(op) >= len(c.operations) {
+		return
+	}
+	c.operations[op].Add(1)
+	if err != nil {
+		c.failures[op].Add(1)
+	}
+}
+
+func (c *Collector) Available(available uint) {
+	c.available.Store(uint64(available))
+}
+
+func (c *Collector) SearchDepth(depth int) {
+	c.depth.observe(float64(depth))
+}
+
+func (c *Collector) Wait(op slotmachine.TransactionType, d time.Duration) {
+	c.wait.observe(d.Seconds())
+}
+
+// Snapshot returns the current values in a form suitable for expvar.
+func (c *Collector) Snapshot() map[string]any {
+	operations := map[string]uint64{}
+	failures := map[string]uint64{}
+	for _, op := range ops {
+		operations[op.String()] = c.operations[op].Load()
+		failures[op.String()] = c.failures[op].Load()
+	}
+	return map[string]any{
+		"operations":       operations,
+		"failures":         failures,
+		"available":        c.available.Load(),
+		"search_depth_sum": c.depth.sum.Load(),
+		"search_count":     c.depth.count.Load(),
+		"wait_seconds_sum": float64(c.wait.sum.Load()) * c.wait.scale,
+		"wait_count":       c.wait.count.Load(),
+	}
+}
+
+// Publish exposes the collector as an expvar variable named after it.
+// Like expvar.Publish, it panics if the name is already in use.
+func (c *Collector) Publish() {
+	expvar.Publish(c.name, expvar.Func(func() any {
+		return c.Snapshot()
+	}))
+}
+
+// ServeHTTP writes this collector in the Prometheus text exposition format.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	Handler(c).ServeHTTP(w, r)
+}
+
+// Handler serves several collectors on one Prometheus scrape endpoint.
+func Handler(collectors ...*Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteText(w, collectors...)
+	})
+}
+
+// WriteText writes collectors in the Prometheus text exposition format.
+func WriteText(w io.Writer, collectors ...*Collector) error {
+	p := &printer{w: w}
+	p.header("slotmachine_operations_total", "counter", "Completed slot machine operations.")
+	for _, c := range collectors {
+		for _, op := range ops {
+			p.printf("slotmachine_operations_total{machine=%q,op=%q} %d\n", c.name, op.String(), c.operations[op].Load())
+		}
+	}
+	p.header("slotmachine_failures_total", "counter", "Slot machine operations that returned an error.")
+	for _, c := range collectors {
+		for _, op := range ops {
+			p.printf("slotmachine_failures_total{machine=%q,op=%q} %d\n", c.name, op.String(), c.failures[op].Load())
+		}
+	}
+	p.header("slotmachine_available", "gauge", "Free slots left.")
+	for _, c := range collectors {
+		p.printf("slotmachine_available{machine=%q} %d\n", c.name, c.available.Load())
+	}
+	p.header("slotmachine_search_depth", "histogram", "Buckets inspected by BookAndSet.")
+	for _, c := range collectors {
+		p.histogram("slotmachine_search_depth", c.name, c.depth)
+	}
+	p.header("slotmachine_wait_seconds", "histogram", "Time spent waiting for the mutex or the transactor channel.")
+	for _, c := range collectors {
+		p.histogram("slotmachine_wait_seconds", c.name, c.wait)
+	}
+	return p.err
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	_, p.err = fmt.Fprintf(p.w, format, args...)
+}
+
+func (p *printer) header(name string, kind string, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p *printer) histogram(name string, machine string, h *histogram) {
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Load()
+		p.printf("%s_bucket{machine=%q,le=\"%g\"} %d\n", name, machine, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	p.printf("%s_bucket{machine=%q,le=\"+Inf\"} %d\n", name, machine, cumulative)
+	p.printf("%s_sum{machine=%q} %g\n", name, machine, float64(h.sum.Load())*h.scale)
+	p.printf("%s_count{machine=%q} %d\n", name, machine, h.count.Load())
+}
@@ -0,0 +1,49 @@
+package slotmachine
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Option configures optional behaviour of a slot machine created by New.
+type Option func(*options)
+
+type options struct {
+	logger     *slog.Logger
+	metrics    Metrics
+	policy     SubscriberPolicy
+	hysteresis Threshold
+	counts     bool
+	strict     bool
+	ranges     *RangeSet
+}
+
+// WithLogger sends debug-level events (bucket fills, parent propagation, search paths
+// and error conditions) to logger. Nothing is formatted unless the logger has debug enabled.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+func (s *SlotMachineStruct[T, V]) apply(o *options) {
+	s.logger = o.logger
+	s.metrics = o.metrics
+	s.subs.policy = o.policy
+	s.hysteresis = o.hysteresis
+	s.counting = o.counts
+	s.strict = o.strict
+	if o.ranges != nil {
+		s.ranges = *o.ranges
+	}
+}
+
+func (s *SlotMachineStruct[T, V]) debugging() bool {
+	return s.logger != nil && s.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// debug must only be called after checking debugging(), so that disabled logging
+// does not pay for building attributes
+func (s *SlotMachineStruct[T, V]) debug(msg string, attrs ...slog.Attr) {
+	s.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
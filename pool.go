@@ -0,0 +1,86 @@
+package slotmachine
+
+import (
+	"io"
+	"iter"
+
+	"golang.org/x/exp/constraints"
+)
+
+func (s *SlotMachineStruct[T, V]) get(slotidx T) (V, bool) {
+	if s.checkBoundaries(slotidx) == OutOfBound {
+		return s.empty, false
+	}
+	leafidx := len(*s.bucketLevels) - 1
+	bucket, offset := s.locate(leafidx, int(slotidx))
+	if (*s.bucketLevels)[leafidx][bucket]&(1<<offset) == 0 {
+		return s.empty, false
+	}
+	return (*s.slice)[slotidx], true
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Get(slotidx T) (V, bool) {
+	return s.st.get(slotidx)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Get(slotidx T) (V, bool) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.get(slotidx)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Get(slotidx T) (V, bool) {
+	var value V
+	var booked bool
+	s.call(func() {
+		value, booked = s.st.get(slotidx)
+	})
+	return value, booked
+}
+
+// Pool is a slot machine that owns its values: there is no slice to share, or to read
+// outside of the machine.
+type Pool[T constraints.Integer, V any] interface {
+	Set(slotidx T, value V) (uint, error)
+	Unset(slotidx T) (uint, error)
+	BookAndSet(value V) (T, uint, error)
+	BookAndSetBatch(slotcount T, value V) ([]T, uint, error)
+	Get(slotidx T) (V, bool)
+	DumpLayout()
+	DumpLayoutTo(w io.Writer) error
+	Layout() LayoutInfo
+	Subscribe(buffer int) (<-chan Event[T, V], func())
+	OnLowWatermark(threshold Threshold, fn func(low bool, available uint))
+	OnExhausted(fn func())
+	OnRecovered(fn func())
+	All() iter.Seq2[T, V]
+	Booked() iter.Seq[T]
+	Free() iter.Seq[T]
+	FreeRanges() iter.Seq2[T, T]
+	Find(pred func(T, V) bool) (T, bool)
+	FindAll(pred func(T, V) bool) []T
+	CountFree(lo T, hi T) (uint, error)
+	Rank(slotidx T) (uint, error)
+	Select(k uint) (T, error)
+	Resize(size int) error
+	Shrink(size int) error
+	SetBoundaries(b Boundaries) error
+	SetRanges(rs RangeSet) error
+	Snapshot() *Snapshot[T, V]
+	Stats() Stats
+}
+
+// NewPool creates a Pool of capacity slots. Free slots read as empty, which may simply be
+// V's zero value. Other arguments and options are those of New.
+func NewPool[T constraints.Integer, V any](
+	cmodel ConcurrencyModel,
+	capacity int,
+	empty V,
+	bucketSize uint8,
+	boundaries *Boundaries,
+	opts ...Option,
+) (Pool[T, V], error) {
+	slice := make([]V, capacity)
+	return New[T, V](cmodel, &slice, empty, bucketSize, boundaries, opts...)
+}
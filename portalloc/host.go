@@ -0,0 +1,92 @@
+package portalloc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// TCP sockets in this state, in /proc/net/tcp, are listening
+const tcpListen = "0A"
+
+// WithProcNetDir sets where tcp, tcp6, udp and udp6 are read from by SyncFromHost.
+// The default is /proc/net.
+func WithProcNetDir(dir string) Option {
+	return func(o *options) {
+		o.procNetDir = dir
+	}
+}
+
+// SyncFromHost marks the ports that other processes listen on, or have bound for UDP, as External,
+// and releases External ports that are not in use anymore. Only the networks the allocator checks
+// are looked at, and ports outside its boundaries are ignored. It can be run periodically.
+func (a *Allocator) SyncFromHost() (booked int, released int, err error) {
+	inUse := make(map[uint16]bool)
+	var files []string
+	if a.networks&TCP != 0 {
+		files = append(files, "tcp", "tcp6")
+	}
+	if a.networks&UDP != 0 {
+		files = append(files, "udp", "udp6")
+	}
+	for _, file := range files {
+		err := readHostPorts(filepath.Join(a.procNetDir, file), strings.HasPrefix(file, "tcp"), inUse)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return 0, 0, err
+		}
+	}
+
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	for port := range inUse {
+		if state, _ := a.sm.Get(port); state != Free {
+			continue
+		}
+		if _, err := a.sm.Set(port, External); err == nil {
+			booked++
+		}
+	}
+	for _, port := range a.sm.FindAll(func(_ uint16, state State) bool { return state == External }) {
+		if !inUse[port] {
+			a.sm.Unset(port)
+			released++
+		}
+	}
+	return booked, released, nil
+}
+
+// readHostPorts adds the local ports of a /proc/net socket table to inUse.
+// For TCP, only listening sockets are considered.
+func readHostPorts(path string, tcp bool, inUse map[uint16]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if tcp && fields[3] != tcpListen {
+			continue
+		}
+		_, hexport, found := strings.Cut(fields[1], ":")
+		port, err := strconv.ParseUint(hexport, 16, 16)
+		if !found || err != nil {
+			return fmt.Errorf("portalloc: malformed local address %q in %s", fields[1], path)
+		}
+		inUse[uint16(port)] = true
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,117 @@
+package portalloc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fusion/slotmachine"
+)
+
+// The kernel's defaults, used when its settings cannot be read
+var defaultEphemeral = slotmachine.Boundaries{Lower: 32768, Upper: 60999}
+
+// WithSysctlDir sets where ip_local_port_range and ip_local_reserved_ports are read from.
+// The default is /proc/sys/net/ipv4.
+func WithSysctlDir(dir string) Option {
+	return func(o *options) {
+		o.sysctlDir = dir
+	}
+}
+
+// NewFromKernel creates an Allocator that stays clear of the kernel's ephemeral port range,
+// and of its reserved ports. Ports are handed out from the boundaries, or ranges, minus those.
+// When the kernel settings cannot be read, its default range, 32768 to 60999, is assumed.
+func NewFromKernel(opts ...Option) (*Allocator, error) {
+	o := newOptions(opts)
+	ephemeral, err := readPortRange(filepath.Join(o.sysctlDir, "ip_local_port_range"))
+	if err != nil {
+		ephemeral = defaultEphemeral
+	}
+	// Without the file, no port is reserved
+	reserved, _ := readReservedPorts(filepath.Join(o.sysctlDir, "ip_local_reserved_ports"))
+
+	rs := slotmachine.RangeSet{Ranges: []slotmachine.Boundaries{o.boundaries}}
+	if o.ranges != nil {
+		rs = *o.ranges
+	}
+	var kept slotmachine.RangeSet
+	for _, r := range rs.Ranges {
+		if r.Lower < ephemeral.Lower {
+			kept.Ranges = append(kept.Ranges, slotmachine.Boundaries{Lower: r.Lower, Upper: min(r.Upper, ephemeral.Lower-1)})
+		}
+		if r.Upper > ephemeral.Upper {
+			kept.Ranges = append(kept.Ranges, slotmachine.Boundaries{Lower: max(r.Lower, ephemeral.Upper+1), Upper: r.Upper})
+		}
+	}
+	if len(kept.Ranges) == 0 {
+		return nil, fmt.Errorf("portalloc: every port is in the ephemeral range %d - %d", ephemeral.Lower, ephemeral.Upper)
+	}
+	kept.Exclude = slices.Concat(rs.Exclude, reserved)
+	o.ranges = &kept
+	return newAllocator(o)
+}
+
+// readPortRange parses ip_local_port_range: two ports, separated by white space
+func readPortRange(path string) (slotmachine.Boundaries, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return slotmachine.Boundaries{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return slotmachine.Boundaries{}, fmt.Errorf("portalloc: malformed port range %q", data)
+	}
+	lower, err := parsePort(fields[0])
+	if err != nil {
+		return slotmachine.Boundaries{}, err
+	}
+	upper, err := parsePort(fields[1])
+	if err != nil {
+		return slotmachine.Boundaries{}, err
+	}
+	if lower > upper {
+		return slotmachine.Boundaries{}, fmt.Errorf("portalloc: malformed port range %q", data)
+	}
+	return slotmachine.Boundaries{Lower: lower, Upper: upper}, nil
+}
+
+// readReservedPorts parses ip_local_reserved_ports: a comma separated list of ports and ranges
+func readReservedPorts(path string) ([]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var reserved []int
+	for _, item := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		if item == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		lower, err := parsePort(first)
+		if err != nil {
+			return nil, err
+		}
+		upper := lower
+		if isRange {
+			if upper, err = parsePort(last); err != nil {
+				return nil, err
+			}
+		}
+		for port := lower; port <= upper; port++ {
+			reserved = append(reserved, port)
+		}
+	}
+	return reserved, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 0 || port >= ports {
+		return 0, fmt.Errorf("portalloc: malformed port %q", s)
+	}
+	return port, nil
+}
@@ -0,0 +1,261 @@
+package portalloc
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fusion/slotmachine"
+)
+
+func TestAllocator(t *testing.T) {
+	t.Log("Testing that ports in use are skipped and marked as external")
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen on loopback:", err)
+	}
+	defer busy.Close()
+	port := uint16(busy.Addr().(*net.TCPAddr).Port)
+	if port == 65535 {
+		t.Skip("no room after the busy port")
+	}
+
+	a, err := New(
+		WithAddress("127.0.0.1"),
+		WithBoundaries(slotmachine.Boundaries{Lower: int(port), Upper: int(port) + 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := a.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if b.Port != port+1 || b.Listener == nil || b.PacketConn != nil {
+		t.Errorf("expected a TCP listener on port %d, got %+v", port+1, b)
+	}
+	if a.State(port) != External || a.State(port+1) != Allocated || a.State(port+2) != Free {
+		t.Error("unexpected states", a.State(port), a.State(port+1), a.State(port+2))
+	}
+	if _, err := net.Listen("tcp", b.Listener.Addr().String()); err == nil {
+		t.Error("the port should be held by the binding")
+	}
+	if _, err := a.Allocate(); err == nil {
+		t.Error("no port should be left")
+	}
+
+	// Once released, the busy port is tried again
+	busy.Close()
+	a.Release(port)
+	if allocated, err := a.Allocate(); err != nil || allocated != port {
+		t.Errorf("expected to allocate port %d, got %d (%v)", port, allocated, err)
+	}
+	if a.Available() != 0 {
+		t.Error("expected no port left", a.Available())
+	}
+}
+
+func TestAllocatorNetworks(t *testing.T) {
+	t.Log("Testing TCP and UDP checks, and limited attempts")
+
+	a, err := New(WithAddress("127.0.0.1"), WithNetworks(TCP|UDP), WithConcurrency(slotmachine.ChannelConcurrency))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := a.Listen()
+	if err != nil {
+		t.Skip("cannot listen on loopback:", err)
+	}
+	if b.Listener == nil || b.PacketConn == nil {
+		t.Errorf("expected both listeners, got %+v", b)
+	}
+	if err := b.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// 192.0.2.1 is reserved for documentation, and never a local address
+	a, _ = New(WithAddress("192.0.2.1"), WithAttempts(2))
+	if _, err := a.Allocate(); err == nil {
+		t.Error("an address that is not local should not be bindable")
+	}
+	if a.Available() != 64512-2 {
+		t.Error("failed ports should be marked external", a.Available())
+	}
+
+	if _, err := New(WithNetworks(0)); err == nil {
+		t.Error("allocating without a network to check should be refused")
+	}
+}
+
+func TestKernelPorts(t *testing.T) {
+	t.Log("Testing the kernel's ephemeral range and reserved ports")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "ip_local_port_range"), []byte("40000\t50000\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "ip_local_reserved_ports"), []byte("1024-1026,30000\n"), 0o644)
+	a, err := NewFromKernel(WithSysctlDir(dir), WithBoundaries(slotmachine.Boundaries{Lower: 1024, Upper: 60000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available := a.Available(); available != (40000-1024)+(60000-50000)-4 {
+		t.Error("unexpected available ports", available)
+	}
+	if port, err := a.Allocate(); err == nil && port != 1027 {
+		t.Error("expected to allocate port 1027, got", port)
+	}
+	for _, port := range []uint16{1025, 30000, 45000} {
+		if a.sm.Set(port, Allocated); a.State(port) != Free {
+			t.Errorf("port %d should not be usable", port)
+		}
+	}
+
+	// Missing files fall back to the kernel's defaults
+	a, err = NewFromKernel(WithSysctlDir(filepath.Join(dir, "missing")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := a.sm.Layout().Ranges.Ranges; len(r) != 2 || r[0].Upper != 32767 || r[1].Lower != 61000 {
+		t.Error("unexpected ranges", r)
+	}
+
+	os.WriteFile(filepath.Join(dir, "ip_local_port_range"), []byte("1024 65535\n"), 0o644)
+	if _, err := NewFromKernel(WithSysctlDir(dir)); err == nil {
+		t.Error("allocating only ephemeral ports should be refused")
+	}
+	os.WriteFile(filepath.Join(dir, "ip_local_port_range"), []byte("nonsense\n"), 0o644)
+	if _, err := readPortRange(filepath.Join(dir, "ip_local_port_range")); err == nil {
+		t.Error("a malformed range should not be read")
+	}
+}
+
+const tcpTable = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0016 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1F91 0100007F:A000 01 00000000:00000000 00:00000000 00000000     0        0 1003 1 0000000000000000 100 0 0 10 0
+`
+
+const tcp6Table = `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000000000000:2328 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 2001 1 0000000000000000 100 0 0 10 0
+`
+
+const udpTable = `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  100: 00000000:14E9 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 3001 2 0000000000000000 0
+`
+
+func TestSyncFromHost(t *testing.T) {
+	t.Log("Testing occupancy seeded from the host's socket tables")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tcp"), []byte(tcpTable), 0o644)
+	os.WriteFile(filepath.Join(dir, "tcp6"), []byte(tcp6Table), 0o644)
+	os.WriteFile(filepath.Join(dir, "udp"), []byte(udpTable), 0o644)
+
+	a, err := New(WithProcNetDir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 8080 and 9000 listen over TCP; 22 is below the boundaries, 8081 is not listening
+	booked, released, err := a.SyncFromHost()
+	if err != nil || booked != 2 || released != 0 {
+		t.Errorf("expected 2 ports booked, got %d, %d (%v)", booked, released, err)
+	}
+	if a.State(8080) != External || a.State(9000) != External || a.State(8081) != Free || a.State(5353) != Free {
+		t.Error("unexpected states", a.State(8080), a.State(9000), a.State(8081), a.State(5353))
+	}
+
+	// Ports that went away are released, ports allocated here are left alone
+	a.sm.Set(8081, Allocated)
+	os.WriteFile(filepath.Join(dir, "tcp"), []byte(strings.ReplaceAll(tcpTable, "1F90", "1F91")), 0o644)
+	os.Remove(filepath.Join(dir, "tcp6"))
+	booked, released, err = a.SyncFromHost()
+	if err != nil || booked != 0 || released != 2 {
+		t.Errorf("expected 2 ports released, got %d, %d (%v)", booked, released, err)
+	}
+	if a.State(8080) != Free || a.State(9000) != Free || a.State(8081) != Allocated {
+		t.Error("unexpected states", a.State(8080), a.State(9000), a.State(8081))
+	}
+
+	a, _ = New(WithProcNetDir(dir), WithNetworks(UDP))
+	if booked, _, _ := a.SyncFromHost(); booked != 1 || a.State(5353) != External {
+		t.Error("expected UDP port 5353 to be booked", booked)
+	}
+
+	os.WriteFile(filepath.Join(dir, "udp"), []byte("header\n 0: nonsense 00000000:0000 07\n"), 0o644)
+	if _, _, err := a.SyncFromHost(); err == nil {
+		t.Error("a malformed table should not be read")
+	}
+}
+
+func TestPortZero(t *testing.T) {
+	t.Log("Testing that port 0, which binds any port, is never handed out")
+
+	for _, opt := range []Option{
+		WithBoundaries(slotmachine.Boundaries{Lower: 0, Upper: 10}),
+		WithRanges(slotmachine.RangeSet{Ranges: []slotmachine.Boundaries{{Lower: 0, Upper: 5}, {Lower: 8, Upper: 13}}, Exclude: []int{13}}),
+	} {
+		a, err := New(WithAddress("127.0.0.1"), opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Available() != 10 {
+			t.Error("port 0 should not be available", a.Available())
+		}
+	}
+	if _, err := New(WithBoundaries(slotmachine.Boundaries{Lower: 0, Upper: 0})); err == nil {
+		t.Error("boundaries holding port 0 alone should be refused")
+	}
+}
+
+func TestSyncFromHostConcurrent(t *testing.T) {
+	t.Log("Testing that syncing with the host leaves ports being allocated alone")
+
+	// Every port of the range shows as listening, as bound ports would
+	const lower, upper = 41000, 41031
+	var table strings.Builder
+	table.WriteString("header\n")
+	for port := lower; port <= upper; port++ {
+		fmt.Fprintf(&table, " %d: 0100007F:%04X 00000000:0000 0A\n", port-lower, port)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "tcp"), []byte(table.String()), 0o644)
+
+	a, err := New(WithAddress("127.0.0.1"), WithProcNetDir(dir), WithBoundaries(slotmachine.Boundaries{Lower: lower, Upper: upper}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			a.SyncFromHost()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	var m sync.Mutex
+	var bindings []*Binding
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b, err := a.Listen(); err == nil {
+				m.Lock()
+				bindings = append(bindings, b)
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	<-done
+	for _, b := range bindings {
+		if state := a.State(b.Port); state != Allocated {
+			t.Errorf("port %d is bound here, but its state is %d", b.Port, state)
+		}
+		b.Close()
+	}
+}
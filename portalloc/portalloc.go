@@ -0,0 +1,258 @@
+// Package portalloc hands out network ports that can actually be bound, using a slot machine
+// to keep track of them.
+package portalloc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/fusion/slotmachine"
+)
+
+// State is what the allocator knows about a port.
+type State uint8
+
+const (
+	Free      State = iota
+	Allocated       // Handed out by this allocator
+	External        // Found in use by someone else
+)
+
+func (s State) String() string {
+	switch s {
+	case Free:
+		return "free"
+	case Allocated:
+		return "allocated"
+	case External:
+		return "external"
+	default:
+		return "unknown"
+	}
+}
+
+// Network selects which protocols a port must be bindable with.
+type Network uint8
+
+const (
+	TCP Network = 1 << iota
+	UDP
+)
+
+const ports = 1 << 16
+
+type options struct {
+	address    string
+	networks   Network
+	boundaries slotmachine.Boundaries
+	ranges     *slotmachine.RangeSet
+	sysctlDir  string
+	procNetDir string
+	cmodel     slotmachine.ConcurrencyModel
+	attempts   int
+	machine    []slotmachine.Option
+}
+
+// Option configures an Allocator.
+type Option func(*options)
+
+// WithAddress sets the address ports are bound on, to check them. The default is every address.
+func WithAddress(address string) Option {
+	return func(o *options) {
+		o.address = address
+	}
+}
+
+// WithNetworks sets the protocols a port must be bindable with. The default is TCP.
+func WithNetworks(networks Network) Option {
+	return func(o *options) {
+		o.networks = networks
+	}
+}
+
+// WithBoundaries sets the ports to hand out. The default is 1024 to 65535.
+// Port 0, which asks the system for any port, is never handed out.
+func WithBoundaries(b slotmachine.Boundaries) Option {
+	return func(o *options) {
+		o.boundaries = b
+	}
+}
+
+// WithRanges sets the ports to hand out as a range set, replacing boundaries.
+func WithRanges(rs slotmachine.RangeSet) Option {
+	return func(o *options) {
+		o.ranges = &rs
+	}
+}
+
+// WithConcurrency sets the underlying slot machine's concurrency model. The default is SyncConcurrency.
+func WithConcurrency(cmodel slotmachine.ConcurrencyModel) Option {
+	return func(o *options) {
+		o.cmodel = cmodel
+	}
+}
+
+// WithAttempts limits how many ports are tried per allocation. The default is to try
+// until no port is left.
+func WithAttempts(attempts int) Option {
+	return func(o *options) {
+		o.attempts = attempts
+	}
+}
+
+// WithMachineOptions passes options, such as a logger or metrics, to the underlying slot machine.
+func WithMachineOptions(opts ...slotmachine.Option) Option {
+	return func(o *options) {
+		o.machine = append(o.machine, opts...)
+	}
+}
+
+// Allocator hands out ports, checking that they can be bound first.
+// It is safe for concurrent use unless created WithConcurrency(NoConcurrency).
+type Allocator struct {
+	// m makes changing a port's state depending on its current state a single step
+	m          sync.Mutex
+	sm         slotmachine.SlotMachine[uint16, State]
+	address    string
+	networks   Network
+	attempts   int
+	procNetDir string
+}
+
+// New creates an Allocator over every port, handing out those within its boundaries.
+func New(opts ...Option) (*Allocator, error) {
+	return newAllocator(newOptions(opts))
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		networks:   TCP,
+		boundaries: slotmachine.Boundaries{Lower: 1024, Upper: ports - 1},
+		sysctlDir:  "/proc/sys/net/ipv4",
+		procNetDir: "/proc/net",
+		cmodel:     slotmachine.SyncConcurrency,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func newAllocator(o options) (*Allocator, error) {
+	if o.networks&(TCP|UDP) == 0 {
+		return nil, fmt.Errorf("portalloc: no network to check ports with")
+	}
+	slice := make([]State, ports)
+	// Binding port 0 would succeed on whatever port the system picks, not the one handed out
+	boundaries := &o.boundaries
+	if boundaries.Lower == 0 {
+		boundaries.Lower = 1
+	}
+	machine := o.machine
+	if o.ranges != nil {
+		boundaries = nil
+		rs := *o.ranges
+		rs.Exclude = append(slices.Clone(rs.Exclude), 0)
+		machine = append([]slotmachine.Option{slotmachine.WithRanges(rs)}, machine...)
+	}
+	sm, err := slotmachine.New[uint16, State](o.cmodel, &slice, Free, uint8(64), boundaries, machine...)
+	if err != nil {
+		return nil, err
+	}
+	return &Allocator{sm: sm, address: o.address, networks: o.networks, attempts: o.attempts, procNetDir: o.procNetDir}, nil
+}
+
+// Binding holds a port open: a listener for TCP, a packet connection for UDP.
+type Binding struct {
+	Port       uint16
+	Listener   net.Listener
+	PacketConn net.PacketConn
+}
+
+// Close closes the listeners, leaving the port allocated.
+func (b *Binding) Close() error {
+	var errs []error
+	if b.Listener != nil {
+		errs = append(errs, b.Listener.Close())
+	}
+	if b.PacketConn != nil {
+		errs = append(errs, b.PacketConn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (a *Allocator) bind(port uint16) (*Binding, error) {
+	hostport := net.JoinHostPort(a.address, strconv.Itoa(int(port)))
+	b := &Binding{Port: port}
+	if a.networks&TCP != 0 {
+		l, err := net.Listen("tcp", hostport)
+		if err != nil {
+			return nil, err
+		}
+		b.Listener = l
+	}
+	if a.networks&UDP != 0 {
+		pc, err := net.ListenPacket("udp", hostport)
+		if err != nil {
+			b.Close()
+			return nil, err
+		}
+		b.PacketConn = pc
+	}
+	return b, nil
+}
+
+// Listen allocates a port and returns it still bound, so nobody can take it before the
+// caller uses it. Ports that cannot be bound are marked External, and the next one is tried.
+func (a *Allocator) Listen() (*Binding, error) {
+	for attempt := 0; a.attempts == 0 || attempt < a.attempts; attempt++ {
+		a.m.Lock()
+		port, _, err := a.sm.BookAndSet(Allocated)
+		a.m.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("portalloc: %w", err)
+		}
+		b, err := a.bind(port)
+		if err == nil {
+			return b, nil
+		}
+		a.m.Lock()
+		a.sm.Set(port, External)
+		a.m.Unlock()
+	}
+	return nil, fmt.Errorf("portalloc: no bindable port after %d attempts", a.attempts)
+}
+
+// Allocate allocates a port that could be bound. The port is released by the check,
+// so another process may still take it: use Listen to avoid that.
+func (a *Allocator) Allocate() (uint16, error) {
+	b, err := a.Listen()
+	if err != nil {
+		return 0, err
+	}
+	return b.Port, b.Close()
+}
+
+// Release returns a port to the allocator, whatever its state.
+func (a *Allocator) Release(port uint16) error {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	_, err := a.sm.Unset(port)
+	return err
+}
+
+// State returns what the allocator knows about a port.
+func (a *Allocator) State(port uint16) State {
+	state, _ := a.sm.Get(port)
+	return state
+}
+
+// Available returns the number of ports left to hand out.
+func (a *Allocator) Available() uint {
+	return a.sm.Layout().Available
+}
@@ -0,0 +1,202 @@
+package slotmachine
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// WithCounts keeps, for every bucket above the leaf level, the number of free slots below it.
+// This costs a word per bucket and a few additions per operation, and makes
+// CountFree, Rank and Select logarithmic instead of linear.
+func WithCounts() Option {
+	return func(o *options) {
+		o.counts = true
+	}
+}
+
+// initCounts fills in free slot counts from the leaf level up, when they are enabled
+func (s *SlotMachineStruct[T, V]) initCounts() {
+	if !s.counting {
+		return
+	}
+	levels := *s.bucketLevels
+	s.counts = make([][]uint64, len(levels))
+	for levelidx := len(levels) - 2; levelidx >= 0; levelidx-- {
+		s.counts[levelidx] = make([]uint64, len(levels[levelidx]))
+		for child := range levels[levelidx+1] {
+			s.counts[levelidx][child/int(s.bucketSize)] += s.childFree(levelidx+1, child)
+		}
+	}
+}
+
+// count adjusts the free slot counts of every bucket above a slot
+func (s *SlotMachineStruct[T, V]) count(slotidx int, delta int) {
+	if s.counts == nil {
+		return
+	}
+	for levelidx := len(*s.bucketLevels) - 2; levelidx >= 0; levelidx-- {
+		bucket, _ := s.locate(levelidx, slotidx)
+		s.counts[levelidx][bucket] += uint64(delta)
+	}
+}
+
+// childFree returns the number of free slots below a bucket
+func (s *SlotMachineStruct[T, V]) childFree(levelidx int, bucket int) uint64 {
+	if levelidx == len(*s.bucketLevels)-1 {
+		return uint64(bits.OnesCount64(^(*s.bucketLevels)[levelidx][bucket] & s.full))
+	}
+	return s.counts[levelidx][bucket]
+}
+
+// freeBefore returns the number of free slots below slotidx, bounds or not
+func (s *SlotMachineStruct[T, V]) freeBefore(slotidx int) uint64 {
+	levels := *s.bucketLevels
+	leafidx := len(levels) - 1
+	if slotidx >= len(*s.slice) {
+		slotidx = len(*s.slice)
+		if s.counts != nil {
+			return s.childFree(0, 0)
+		}
+	}
+	var free uint64
+	if s.counts == nil {
+		leaf := levels[leafidx]
+		bucket, offset := slotidx/int(s.bucketSize), slotidx%int(s.bucketSize)
+		for _, word := range leaf[:bucket] {
+			free += uint64(bits.OnesCount64(^word & s.full))
+		}
+		if bucket < len(leaf) {
+			free += uint64(bits.OnesCount64(^leaf[bucket] & (1<<offset - 1)))
+		}
+		return free
+	}
+	for levelidx := 0; levelidx < leafidx; levelidx++ {
+		bucket, offset := s.locate(levelidx, slotidx)
+		for child := bucket * int(s.bucketSize); child < bucket*int(s.bucketSize)+offset; child++ {
+			free += s.childFree(levelidx+1, child)
+		}
+	}
+	bucket, offset := s.locate(leafidx, slotidx)
+	return free + uint64(bits.OnesCount64(^levels[leafidx][bucket]&(1<<offset-1)))
+}
+
+// Slots that cannot be booked are marked as taken, so free slots are always usable ones
+
+func (s *SlotMachineStruct[T, V]) countFree(lo T, hi T) (uint, error) {
+	if lo > hi {
+		return 0, fmt.Errorf("SlotMachine: invalid range %d - %d", lo, hi)
+	}
+	// Clamp in T's domain first: int(hi) would wrap for the largest uint64 indices
+	last := len(*s.slice) - 1
+	if hi < 0 || (lo > 0 && uint64(lo) > uint64(last)) {
+		return 0, nil
+	}
+	if uint64(hi) < uint64(last) {
+		last = int(hi)
+	}
+	first := max(int(lo), 0)
+	return uint(s.freeBefore(last+1) - s.freeBefore(first)), nil
+}
+
+func (s *SlotMachineStruct[T, V]) rank(slotidx T) (uint, error) {
+	if int(slotidx) < 0 || int(slotidx) > len(*s.slice) {
+		return 0, fmt.Errorf("SlotMachine: slot index %d is out of range", slotidx)
+	}
+	return uint(s.usableBefore(int(slotidx)) - s.freeBefore(int(slotidx))), nil
+}
+
+func (s *SlotMachineStruct[T, V]) sel(k uint) (T, error) {
+	if k >= s.available {
+		return 0, fmt.Errorf("SlotMachine: no free slot of rank %d, only %d available", k, s.available)
+	}
+	target := uint64(k)
+	levels := *s.bucketLevels
+	leafidx := len(levels) - 1
+	var bucket int
+	if s.counts == nil {
+		for bucket = 0; bucket < len(levels[leafidx]); bucket++ {
+			free := s.childFree(leafidx, bucket)
+			if target < free {
+				break
+			}
+			target -= free
+		}
+	} else {
+		for levelidx := 0; levelidx < leafidx; levelidx++ {
+			child := bucket * int(s.bucketSize)
+			for ; ; child++ {
+				free := s.childFree(levelidx+1, child)
+				if target < free {
+					break
+				}
+				target -= free
+			}
+			bucket = child
+		}
+	}
+	free := ^levels[leafidx][bucket] & s.full
+	for ; target > 0; target-- {
+		free &= free - 1
+	}
+	return T(bucket*int(s.bucketSize) + bits.TrailingZeros64(free)), nil
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) CountFree(lo T, hi T) (uint, error) {
+	return s.st.countFree(lo, hi)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Rank(slotidx T) (uint, error) {
+	return s.st.rank(slotidx)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Select(k uint) (T, error) {
+	return s.st.sel(k)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) CountFree(lo T, hi T) (uint, error) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.countFree(lo, hi)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Rank(slotidx T) (uint, error) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.rank(slotidx)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Select(k uint) (T, error) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.sel(k)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) CountFree(lo T, hi T) (uint, error) {
+	var free uint
+	var err error
+	s.call(func() {
+		free, err = s.st.countFree(lo, hi)
+	})
+	return free, err
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Rank(slotidx T) (uint, error) {
+	var rank uint
+	var err error
+	s.call(func() {
+		rank, err = s.st.rank(slotidx)
+	})
+	return rank, err
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Select(k uint) (T, error) {
+	var slot T
+	var err error
+	s.call(func() {
+		slot, err = s.st.sel(k)
+	})
+	return slot, err
+}
@@ -0,0 +1,173 @@
+package slotmachine
+
+import (
+	"fmt"
+)
+
+// resizedRanges returns the range set after resizing: ranges are cut at the new end,
+// and an upper boundary at the end of the slice follows it
+func (s *SlotMachineStruct[T, V]) resizedRanges(size int) RangeSet {
+	var rs RangeSet
+	for _, r := range s.ranges.Ranges {
+		if r.Upper == len(*s.slice)-1 || r.Upper >= size {
+			r.Upper = size - 1
+		}
+		if r.Lower <= r.Upper {
+			rs.Ranges = append(rs.Ranges, r)
+		}
+	}
+	for _, slot := range s.ranges.Exclude {
+		if slot < size {
+			rs.Exclude = append(rs.Exclude, slot)
+		}
+	}
+	return rs
+}
+
+// resized brings counts and availability in line with the new slice
+func (s *SlotMachineStruct[T, V]) resized() {
+	s.remasked()
+	if s.metrics != nil {
+		s.metrics.Available(s.available)
+	}
+	s.checkWatermarks()
+}
+
+func (s *SlotMachineStruct[T, V]) checkSize(size int) error {
+	if size <= 0 {
+		return fmt.Errorf("SlotMachine: size must be positive, not %d", size)
+	}
+	if uint64(size-1) > maxIndex[T]() {
+		return fmt.Errorf("SlotMachine: %d slots cannot be indexed with %T, whose largest value is %d", size, T(0), maxIndex[T]())
+	}
+	return nil
+}
+
+func (s *SlotMachineStruct[T, V]) grow(newSlice *[]V) error {
+	oldSize := len(*s.slice)
+	size := len(*newSlice)
+	if size < oldSize {
+		return fmt.Errorf("SlotMachine: cannot grow from %d to %d slots", oldSize, size)
+	}
+	if err := s.checkSize(size); err != nil {
+		return err
+	}
+	if size > 0 && oldSize > 0 && &(*newSlice)[0] != &(*s.slice)[0] {
+		copy(*newSlice, *s.slice)
+	}
+	rs := s.resizedRanges(size)
+	s.relevel(size, rs)
+	s.ranges = rs
+	s.boundaries = rs.span()
+	s.slice = newSlice
+	s.resized()
+	return nil
+}
+
+func (s *SlotMachineStruct[T, V]) shrink(size int) error {
+	oldSize := len(*s.slice)
+	if size > oldSize {
+		return fmt.Errorf("SlotMachine: cannot shrink from %d to %d slots", oldSize, size)
+	}
+	if err := s.checkSize(size); err != nil {
+		return err
+	}
+	if size <= s.boundaries.Lower {
+		return fmt.Errorf("SlotMachine: cannot shrink to %d slots, below the lower boundary %d", size, s.boundaries.Lower)
+	}
+	for slot := range s.bitmap().booked() {
+		if int(slot) >= size {
+			return fmt.Errorf("SlotMachine: cannot shrink to %d slots, slot %d is booked", size, slot)
+		}
+	}
+	for _, slot := range s.orphans {
+		if int(slot) >= size {
+			return fmt.Errorf("SlotMachine: cannot shrink to %d slots, slot %d is booked outside boundaries", size, slot)
+		}
+	}
+	rs := s.resizedRanges(size)
+	s.relevel(size, rs)
+	s.ranges = rs
+	s.boundaries = rs.span()
+	*s.slice = (*s.slice)[:size:size]
+	s.resized()
+	return nil
+}
+
+func (s *SlotMachineStruct[T, V]) resize(size int) error {
+	if size <= len(*s.slice) {
+		return s.shrink(size)
+	}
+	if err := s.checkSize(size); err != nil {
+		return err
+	}
+	grown := make([]V, size)
+	for i := len(*s.slice); i < size; i++ {
+		grown[i] = s.empty
+	}
+	oldSlice := s.slice
+	if err := s.grow(&grown); err != nil {
+		return err
+	}
+	// Hand the new slice back to whoever owns the old one
+	*oldSlice = grown
+	s.slice = oldSlice
+	return nil
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Grow(newSlice *[]V) error {
+	return s.st.grow(newSlice)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Resize(size int) error {
+	return s.st.resize(size)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Shrink(size int) error {
+	return s.st.shrink(size)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Grow(newSlice *[]V) error {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.grow(newSlice)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Resize(size int) error {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.resize(size)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Shrink(size int) error {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.shrink(size)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Grow(newSlice *[]V) error {
+	var err error
+	s.call(func() {
+		err = s.st.grow(newSlice)
+	})
+	return err
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Resize(size int) error {
+	var err error
+	s.call(func() {
+		err = s.st.resize(size)
+	})
+	return err
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Shrink(size int) error {
+	var err error
+	s.call(func() {
+		err = s.st.shrink(size)
+	})
+	return err
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fusion/slotmachine"
+)
+
+func TestServerState(t *testing.T) {
+	t.Log("Testing the API, and persisting the machine")
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	if sm, err := Load(slotmachine.SyncConcurrency, state); sm != nil || err != nil {
+		t.Fatal("a missing state file should load nothing", err)
+	}
+	slice := make([]json.RawMessage, 64)
+	sm, _ := slotmachine.New[Slot, json.RawMessage](slotmachine.SyncConcurrency, &slice, nil, uint8(8), nil)
+	srv := New(sm, WithState(state))
+
+	for _, call := range []struct {
+		method, path, body string
+		status             int
+		response           string
+	}{
+		{"POST", "/book", `{"value": {"owner": "a"}}`, http.StatusOK, `{"slot":0,"available":63}`},
+		{"POST", "/book/range", `{"count": 2, "value": "b"}`, http.StatusOK, `{"slots":[1,2],"available":61}`},
+		{"PUT", "/slots/40", `{"value": 40}`, http.StatusOK, `{"available":60}`},
+		{"PUT", "/slots/64", `{"value": 64}`, http.StatusConflict, `{"error":"slot index 64 is out of bounds"}`},
+		{"GET", "/slots/0", ``, http.StatusOK, `{"slot":0,"value":{"owner":"a"},"booked":true}`},
+		{"DELETE", "/slots/1", ``, http.StatusOK, `{"available":61}`},
+		{"GET", "/slots/x", ``, http.StatusBadRequest, `{"error":"invalid id \"x\""}`},
+		{"POST", "/book", `{`, http.StatusBadRequest, `{"error":"unexpected EOF"}`},
+		{"GET", "/free", ``, http.StatusOK, `[{"lower":1,"upper":1},{"lower":3,"upper":39},{"lower":41,"upper":63}]`},
+		{"GET", "/count?lo=0&hi=9", ``, http.StatusOK, `{"free":8}`},
+		{"GET", "/select/1", ``, http.StatusOK, `{"slot":3}`},
+		{"PUT", "/ranges", `{"ranges": [{"lower": 0, "upper": 39}]}`, http.StatusOK, `{"error":"SlotMachine: booked slots outside boundaries: 40","outside":[40]}`},
+	} {
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, httptest.NewRequest(call.method, call.path, strings.NewReader(call.body)))
+		if rec.Code != call.status || strings.TrimSpace(rec.Body.String()) != call.response {
+			t.Errorf("%s %s: got %d %s", call.method, call.path, rec.Code, rec.Body.String())
+		}
+	}
+
+	restored, err := Load(slotmachine.ChannelConcurrency, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := restored.Layout(); info.Available != 38 || info.Boundaries.Upper != 39 {
+		t.Errorf("unexpected restored layout %+v", info)
+	}
+	if value, booked := restored.Get(2); !booked || string(value) != `"b"` {
+		t.Error("unexpected restored value", string(value))
+	}
+}
@@ -0,0 +1,420 @@
+// Package server exposes a slot machine over HTTP, with a JSON API.
+// The client package talks to it.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/fusion/slotmachine"
+)
+
+// Slot is the slot index type served. Values are kept as raw JSON, whatever their type.
+type Slot = uint32
+
+// Machine is the kind of slot machine a server exposes.
+type Machine = slotmachine.SlotMachine[Slot, json.RawMessage]
+
+type options struct {
+	state string
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithState saves a snapshot of the machine to path after every change.
+func WithState(path string) Option {
+	return func(o *options) {
+		o.state = path
+	}
+}
+
+// Server is an http.Handler serving a slot machine:
+//
+//	POST   /book          {"value": v}              books a slot
+//	POST   /book/range    {"count": n, "value": v}  books several slots
+//	GET    /slots                                   lists booked slots and their values
+//	GET    /slots/{id}                              returns a slot's value
+//	PUT    /slots/{id}    {"value": v}              sets a slot
+//	DELETE /slots/{id}                              releases a slot
+//	GET    /free                                    lists runs of free slots
+//	GET    /count?lo=&hi=                           counts free slots
+//	GET    /rank/{id}                               counts booked slots below a slot
+//	GET    /select/{k}                              returns the k-th free slot
+//	POST   /resize        {"size": n, "shrink": b}  resizes the machine
+//	PUT    /ranges        {"ranges": [...]}         changes the usable slots
+//	GET    /stats                                   returns the layout
+//	GET    /fragmentation                           returns fragmentation statistics
+//	GET    /snapshot                                returns a snapshot
+//	GET    /events                                  streams events, as JSON lines
+//
+// Failed operations answer with {"error": message}.
+type Server struct {
+	sm    Machine
+	state string
+	m     sync.Mutex // Serializes saves
+	mux   *http.ServeMux
+}
+
+// New creates a server for a slot machine.
+func New(sm Machine, opts ...Option) *Server {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := &Server{sm: sm, state: o.state, mux: http.NewServeMux()}
+	s.mux.HandleFunc("POST /book", s.book)
+	s.mux.HandleFunc("POST /book/range", s.bookRange)
+	s.mux.HandleFunc("GET /slots", s.slots)
+	s.mux.HandleFunc("GET /slots/{id}", s.get)
+	s.mux.HandleFunc("PUT /slots/{id}", s.set)
+	s.mux.HandleFunc("DELETE /slots/{id}", s.unset)
+	s.mux.HandleFunc("GET /free", s.free)
+	s.mux.HandleFunc("GET /count", s.count)
+	s.mux.HandleFunc("GET /rank/{id}", s.rank)
+	s.mux.HandleFunc("GET /select/{k}", s.sel)
+	s.mux.HandleFunc("POST /resize", s.resize)
+	s.mux.HandleFunc("PUT /ranges", s.ranges)
+	s.mux.HandleFunc("GET /stats", s.stats)
+	s.mux.HandleFunc("GET /fragmentation", s.fragmentation)
+	s.mux.HandleFunc("GET /snapshot", s.snapshot)
+	s.mux.HandleFunc("GET /events", s.events)
+	return s
+}
+
+// Load restores a machine from a state file written WithState. It returns nil, and no error,
+// if the file does not exist.
+func Load(cmodel slotmachine.ConcurrencyModel, path string, opts ...slotmachine.Option) (Machine, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	snap, err := slotmachine.ReadSnapshot[Slot, json.RawMessage](f)
+	if err != nil {
+		return nil, err
+	}
+	sm, _, err := slotmachine.Restore(cmodel, snap, opts...)
+	return sm, err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Save writes a snapshot of the machine to the state file, if there is one.
+// The file is replaced atomically.
+func (s *Server) Save() error {
+	if s.state == "" {
+		return nil
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(s.state), filepath.Base(s.state)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := s.sm.Snapshot().WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.state)
+}
+
+type errorResponse struct {
+	Error   string `json:"error"`
+	Outside []Slot `json:"outside,omitempty"`
+	Refused bool   `json:"refused,omitempty"`
+}
+
+func reply(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func fail(w http.ResponseWriter, status int, err error) {
+	resp := errorResponse{Error: err.Error()}
+	var outside *slotmachine.BookedOutsideError[Slot]
+	if errors.As(err, &outside) {
+		resp.Outside, resp.Refused = outside.Slots, outside.Refused
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// changed saves the state after a change, and replies
+func (s *Server) changed(w http.ResponseWriter, v any) {
+	if err := s.Save(); err != nil {
+		fail(w, http.StatusInternalServerError, fmt.Errorf("state not saved: %w", err))
+		return
+	}
+	reply(w, v)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func slotParam(w http.ResponseWriter, r *http.Request, name string) (Slot, bool) {
+	n, err := strconv.ParseUint(r.PathValue(name), 10, 32)
+	if err != nil {
+		fail(w, http.StatusBadRequest, fmt.Errorf("invalid %s %q", name, r.PathValue(name)))
+		return 0, false
+	}
+	return Slot(n), true
+}
+
+type valueRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+type slotResponse struct {
+	Slot      Slot `json:"slot"`
+	Available uint `json:"available"`
+}
+
+func (s *Server) book(w http.ResponseWriter, r *http.Request) {
+	var req valueRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	slot, available, err := s.sm.BookAndSet(req.Value)
+	if err != nil {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	s.changed(w, slotResponse{Slot: slot, Available: available})
+}
+
+type rangeRequest struct {
+	Count Slot            `json:"count"`
+	Value json.RawMessage `json:"value"`
+}
+
+type slotsResponse struct {
+	Slots     []Slot `json:"slots"`
+	Available uint   `json:"available"`
+}
+
+func (s *Server) bookRange(w http.ResponseWriter, r *http.Request) {
+	var req rangeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	slots, available, err := s.sm.BookAndSetBatch(req.Count, req.Value)
+	if err != nil {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	s.changed(w, slotsResponse{Slots: slots, Available: available})
+}
+
+func (s *Server) slots(w http.ResponseWriter, r *http.Request) {
+	booked := []slotmachine.SnapshotSlot[Slot, json.RawMessage]{}
+	for slot, value := range s.sm.All() {
+		booked = append(booked, slotmachine.SnapshotSlot[Slot, json.RawMessage]{Slot: slot, Value: value})
+	}
+	reply(w, booked)
+}
+
+type getResponse struct {
+	Slot   Slot            `json:"slot"`
+	Value  json.RawMessage `json:"value"`
+	Booked bool            `json:"booked"`
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotParam(w, r, "id")
+	if !ok {
+		return
+	}
+	value, booked := s.sm.Get(slot)
+	reply(w, getResponse{Slot: slot, Value: value, Booked: booked})
+}
+
+type availableResponse struct {
+	Available uint `json:"available"`
+}
+
+func (s *Server) set(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotParam(w, r, "id")
+	if !ok {
+		return
+	}
+	var req valueRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	available, err := s.sm.Set(slot, req.Value)
+	if err != nil {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	s.changed(w, availableResponse{available})
+}
+
+func (s *Server) unset(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotParam(w, r, "id")
+	if !ok {
+		return
+	}
+	available, err := s.sm.Unset(slot)
+	if err != nil {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	s.changed(w, availableResponse{available})
+}
+
+func (s *Server) free(w http.ResponseWriter, r *http.Request) {
+	ranges := []slotmachine.Boundaries{}
+	for first, last := range s.sm.FreeRanges() {
+		ranges = append(ranges, slotmachine.Boundaries{Lower: int(first), Upper: int(last)})
+	}
+	reply(w, ranges)
+}
+
+func (s *Server) count(w http.ResponseWriter, r *http.Request) {
+	lo, errLo := strconv.ParseUint(r.URL.Query().Get("lo"), 10, 32)
+	hi, errHi := strconv.ParseUint(r.URL.Query().Get("hi"), 10, 32)
+	if errLo != nil || errHi != nil {
+		fail(w, http.StatusBadRequest, fmt.Errorf("invalid range %q - %q", r.URL.Query().Get("lo"), r.URL.Query().Get("hi")))
+		return
+	}
+	free, err := s.sm.CountFree(Slot(lo), Slot(hi))
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	reply(w, map[string]uint{"free": free})
+}
+
+func (s *Server) rank(w http.ResponseWriter, r *http.Request) {
+	slot, ok := slotParam(w, r, "id")
+	if !ok {
+		return
+	}
+	rank, err := s.sm.Rank(slot)
+	if err != nil {
+		fail(w, http.StatusBadRequest, err)
+		return
+	}
+	reply(w, map[string]uint{"rank": rank})
+}
+
+func (s *Server) sel(w http.ResponseWriter, r *http.Request) {
+	k, ok := slotParam(w, r, "k")
+	if !ok {
+		return
+	}
+	slot, err := s.sm.Select(uint(k))
+	if err != nil {
+		fail(w, http.StatusNotFound, err)
+		return
+	}
+	reply(w, map[string]Slot{"slot": slot})
+}
+
+type resizeRequest struct {
+	Size   int  `json:"size"`
+	Shrink bool `json:"shrink"`
+}
+
+func (s *Server) resize(w http.ResponseWriter, r *http.Request) {
+	var req resizeRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	resize := s.sm.Resize
+	if req.Shrink {
+		resize = s.sm.Shrink
+	}
+	if err := resize(req.Size); err != nil {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	s.changed(w, s.sm.Layout())
+}
+
+func (s *Server) ranges(w http.ResponseWriter, r *http.Request) {
+	var rs slotmachine.RangeSet
+	if !decode(w, r, &rs) {
+		return
+	}
+	err := s.sm.SetRanges(rs)
+	var outside *slotmachine.BookedOutsideError[Slot]
+	if err != nil && (!errors.As(err, &outside) || outside.Refused) {
+		fail(w, http.StatusConflict, err)
+		return
+	}
+	if saveErr := s.Save(); saveErr != nil {
+		fail(w, http.StatusInternalServerError, fmt.Errorf("state not saved: %w", saveErr))
+		return
+	}
+	if err != nil {
+		// Applied, but reported
+		fail(w, http.StatusOK, err)
+		return
+	}
+	reply(w, s.sm.Layout())
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	reply(w, s.sm.Layout())
+}
+
+func (s *Server) fragmentation(w http.ResponseWriter, r *http.Request) {
+	reply(w, s.sm.Stats())
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	reply(w, s.sm.Snapshot())
+}
+
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	events, cancel := s.sm.Subscribe(64)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/jsonl")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := enc.Encode(event); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,315 @@
+//go:build linux || darwin
+
+package slotmachine
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/exp/constraints"
+)
+
+const (
+	sharedMagic   = "SLOTMACH"
+	sharedVersion = 3
+)
+
+// sharedHeader starts a state file. Slots' owners follow it, then the bucket levels, root first.
+type sharedHeader struct {
+	magic      [8]byte
+	version    uint32
+	bucketSize uint32
+	size       uint64
+	lower      int64
+	upper      int64
+	ranges     uint64 // Checksum of the normalized range set, which masks the leaf level
+	available  uint64 // For anyone inspecting the file
+	generation uint64 // Bumped by every change
+}
+
+// sharedOwner is the process that booked a slot. Its start time tells it apart from a later
+// process reusing its PID; it is 0 where start times cannot be read.
+type sharedOwner struct {
+	pid   int32
+	_     uint32
+	start uint64
+}
+
+// Shared is a slot machine whose state lives in a memory-mapped file, so that processes on
+// the same host can share it. The file is locked with flock for every operation.
+// Each booked slot records the PID and start time of the process that booked it, so that bookings
+// of dead processes can be reclaimed. Reclaiming only happens when Book finds no free slot, or
+// when Reclaim is called. On macOS, start times are not recorded: bookings of a dead process whose
+// PID was reused are not reclaimed. Every process must open the file with the same arguments:
+// other sizes, boundaries or ranges are refused.
+type Shared[T constraints.Integer] struct {
+	m     sync.Mutex // flock does not exclude goroutines sharing a file descriptor
+	st    SlotMachineStruct[T, sharedOwner]
+	f     *os.File
+	data  []byte
+	hdr   *sharedHeader
+	seen  uint64 // Last generation this process knows the counts of
+	owner sharedOwner
+}
+
+func sharedLength(size int, levels [][]uint64) int {
+	length := int(unsafe.Sizeof(sharedHeader{})) + size*int(unsafe.Sizeof(sharedOwner{}))
+	for _, level := range levels {
+		length += len(level) * 8
+	}
+	return length
+}
+
+// OpenShared opens a state file, creating it if it does not exist or is empty.
+// Arguments and options are those of New, the file holding both values and buckets.
+func OpenShared[T constraints.Integer](
+	path string,
+	size int,
+	bucketSize uint8,
+	boundaries *Boundaries,
+	opts ...Option,
+) (*Shared[T], error) {
+	if err := validate[T](size, bucketSize, boundaries); err != nil {
+		return nil, err
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	b := Boundaries{0, size - 1}
+	if boundaries != nil {
+		b = *boundaries
+	}
+	rs := RangeSet{Ranges: []Boundaries{b}}
+	if o.ranges != nil {
+		if boundaries != nil {
+			return nil, fmt.Errorf("boundaries and ranges cannot both be set")
+		}
+		var err error
+		if rs, err = o.ranges.normalize(size); err != nil {
+			return nil, err
+		}
+		b = rs.span()
+	}
+	o.ranges = &rs
+	full := ^uint64(0) >> (64 - bucketSize)
+	fresh := buildLevels(size, bucketSize, full)
+	length := sharedLength(size, fresh)
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+
+	s, err := mapShared[T](f, size, bucketSize, b, rangeSum(rs), fresh, length)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.st.apply(&o)
+	s.st.initMask()
+	s.seen = s.hdr.generation
+	s.hdr.available = uint64(s.st.available)
+	return s, nil
+}
+
+// rangeSum fingerprints a normalized range set: every process must mask the shared leaf level alike
+func rangeSum(rs RangeSet) uint64 {
+	h := fnv.New64a()
+	for _, r := range rs.Ranges {
+		binary.Write(h, binary.LittleEndian, [2]int64{int64(r.Lower), int64(r.Upper)})
+	}
+	for _, slot := range rs.Exclude {
+		binary.Write(h, binary.LittleEndian, int64(-1-slot))
+	}
+	return h.Sum64()
+}
+
+func mapShared[T constraints.Integer](f *os.File, size int, bucketSize uint8, b Boundaries, ranges uint64, fresh [][]uint64, length int) (*Shared[T], error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	created := info.Size() == 0
+	if created {
+		if err := f.Truncate(int64(length)); err != nil {
+			return nil, err
+		}
+	} else if info.Size() != int64(length) {
+		return nil, fmt.Errorf("SlotMachine: %s holds %d bytes, expected %d", f.Name(), info.Size(), length)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, length, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := (*sharedHeader)(unsafe.Pointer(&data[0]))
+	expected := sharedHeader{
+		version:    sharedVersion,
+		bucketSize: uint32(bucketSize),
+		size:       uint64(size),
+		lower:      int64(b.Lower),
+		upper:      int64(b.Upper),
+		ranges:     ranges,
+	}
+	copy(expected.magic[:], sharedMagic)
+	if created {
+		*hdr = expected
+	} else if hdr.magic != expected.magic || hdr.version != expected.version || hdr.bucketSize != expected.bucketSize ||
+		hdr.size != expected.size || hdr.lower != expected.lower || hdr.upper != expected.upper || hdr.ranges != expected.ranges {
+		syscall.Munmap(data)
+		return nil, fmt.Errorf("SlotMachine: %s does not hold a state for these arguments", f.Name())
+	}
+
+	offset := int(unsafe.Sizeof(sharedHeader{}))
+	owners := unsafe.Slice((*sharedOwner)(unsafe.Pointer(&data[offset])), size)
+	offset += size * int(unsafe.Sizeof(sharedOwner{}))
+	levels := make([][]uint64, len(fresh))
+	for i, level := range fresh {
+		levels[i] = unsafe.Slice((*uint64)(unsafe.Pointer(&data[offset])), len(level))
+		if created {
+			copy(levels[i], level)
+		}
+		offset += len(level) * 8
+	}
+
+	pid := os.Getpid()
+	start, _ := processStart(pid)
+	s := &Shared[T]{f: f, data: data, hdr: hdr, owner: sharedOwner{pid: int32(pid), start: start}}
+	s.st.slice = &owners
+	s.st.bucketSize = bucketSize
+	s.st.full = ^uint64(0) >> (64 - bucketSize)
+	s.st.bucketLevels = &levels
+	s.st.boundaries = b
+	return s, nil
+}
+
+// lock takes the file, and catches up with changes made by other processes
+func (s *Shared[T]) lock() error {
+	s.m.Lock()
+	if err := syscall.Flock(int(s.f.Fd()), syscall.LOCK_EX); err != nil {
+		s.m.Unlock()
+		return err
+	}
+	if s.hdr.generation != s.seen {
+		s.st.initCounts()
+		s.st.recount()
+		s.seen = s.hdr.generation
+	}
+	return nil
+}
+
+func (s *Shared[T]) unlock(changed bool) {
+	if changed {
+		s.hdr.generation++
+		s.seen = s.hdr.generation
+		s.hdr.available = uint64(s.st.available)
+	}
+	syscall.Flock(int(s.f.Fd()), syscall.LOCK_UN)
+	s.m.Unlock()
+}
+
+// Book books the first free slot for this process. When none is left, bookings of dead
+// processes are reclaimed first.
+func (s *Shared[T]) Book() (T, uint, error) {
+	if err := s.lock(); err != nil {
+		return 0, 0, err
+	}
+	slot, available, err := s.st.bookAndSet(s.owner)
+	reclaimed := 0
+	if err != nil {
+		if reclaimed = s.reclaim(); reclaimed > 0 {
+			slot, available, err = s.st.bookAndSet(s.owner)
+		}
+	}
+	s.unlock(err == nil || reclaimed > 0)
+	return slot, available, err
+}
+
+// Set books a slot for this process.
+func (s *Shared[T]) Set(slotidx T) (uint, error) {
+	if err := s.lock(); err != nil {
+		return 0, err
+	}
+	available, err := s.st.set(slotidx, s.owner)
+	s.unlock(err == nil)
+	return available, err
+}
+
+// Unset releases a slot, whichever process booked it.
+func (s *Shared[T]) Unset(slotidx T) (uint, error) {
+	if err := s.lock(); err != nil {
+		return 0, err
+	}
+	available, err := s.st.unset(slotidx)
+	s.unlock(err == nil)
+	return available, err
+}
+
+// Owner returns the PID of the process that booked a slot.
+func (s *Shared[T]) Owner(slotidx T) (int, bool) {
+	if err := s.lock(); err != nil {
+		return 0, false
+	}
+	defer s.unlock(false)
+
+	owner, booked := s.st.get(slotidx)
+	return int(owner.pid), booked
+}
+
+// Reclaim releases the slots booked by processes that are not running anymore, including those
+// whose PID now belongs to a process started later.
+func (s *Shared[T]) Reclaim() (int, error) {
+	if err := s.lock(); err != nil {
+		return 0, err
+	}
+	reclaimed := s.reclaim()
+	s.unlock(reclaimed > 0)
+	return reclaimed, nil
+}
+
+func (s *Shared[T]) reclaim() int {
+	dead := s.st.findAll(func(_ T, owner sharedOwner) bool {
+		if errors.Is(syscall.Kill(int(owner.pid), 0), syscall.ESRCH) {
+			return true
+		}
+		if owner.start == 0 {
+			return false
+		}
+		start, ok := processStart(int(owner.pid))
+		return ok && start != owner.start
+	})
+	for _, slot := range dead {
+		s.st.unset(slot)
+	}
+	return len(dead)
+}
+
+// Layout returns the structure and occupancy of the shared machine.
+func (s *Shared[T]) Layout() LayoutInfo {
+	if err := s.lock(); err != nil {
+		return LayoutInfo{}
+	}
+	defer s.unlock(false)
+
+	return s.st.layout()
+}
+
+// Close unmaps and closes the state file. Bookings stay in it.
+func (s *Shared[T]) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return errors.Join(syscall.Munmap(s.data), s.f.Close())
+}
@@ -0,0 +1,6 @@
+package slotmachine
+
+// processStart is not available on macOS: bookings only record PIDs there.
+func processStart(pid int) (uint64, bool) {
+	return 0, false
+}
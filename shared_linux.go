@@ -0,0 +1,26 @@
+package slotmachine
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// processStart returns when a process started, in clock ticks since boot.
+func processStart(pid int) (uint64, bool) {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return 0, false
+	}
+	// The command name, in parentheses, may contain spaces: start time is the 20th field after it
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0, false
+	}
+	fields := bytes.Fields(stat[i+1:])
+	if len(fields) < 20 {
+		return 0, false
+	}
+	start, err := strconv.ParseUint(string(fields[19]), 10, 64)
+	return start, err == nil
+}
@@ -0,0 +1,125 @@
+//go:build linux || darwin
+
+package slotmachine
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestShared(t *testing.T) {
+	t.Log("Testing a machine shared through a state file")
+
+	path := filepath.Join(t.TempDir(), "ports.state")
+	first, err := OpenShared[uint16](path, 1000, uint8(16), &Boundaries{100, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another process would open the file the same way
+	second, err := OpenShared[uint16](path, 1000, uint8(16), &Boundaries{100, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	slot, available, err := first.Book()
+	if err != nil || slot != 100 || available != 899 {
+		t.Errorf("expected to book slot 100 with 899 left, got %d, %d (%v)", slot, available, err)
+	}
+	slot, available, err = second.Book()
+	if err != nil || slot != 101 || available != 898 {
+		t.Errorf("expected to book slot 101 with 898 left, got %d, %d (%v)", slot, available, err)
+	}
+	second.Set(500)
+	first.Unset(100)
+	if info := first.Layout(); info.Available != 898 {
+		t.Error("changes from the other handle should be seen", info.Available)
+	}
+	if pid, booked := first.Owner(500); !booked || pid != os.Getpid() {
+		t.Error("unexpected owner", pid, booked)
+	}
+	if _, err := OpenShared[uint16](path, 1000, uint8(16), nil); err == nil {
+		t.Error("opening with other boundaries should be refused")
+	}
+	if _, err := OpenShared[uint16](path, 1000, uint8(16), nil, WithRanges(RangeSet{Ranges: []Boundaries{{100, 999}}, Exclude: []int{200}})); err == nil {
+		t.Error("opening with other exclusions should be refused")
+	}
+	same, err := OpenShared[uint16](path, 1000, uint8(16), nil, WithRanges(RangeSet{Ranges: []Boundaries{{500, 999}, {100, 499}}}))
+	if err != nil {
+		t.Error("the same range set should be accepted", err)
+	} else {
+		same.Close()
+	}
+
+	// Bookings of a process that is gone are reclaimed
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skip("cannot run a process:", err)
+	}
+	second.lock()
+	(*second.st.slice)[500] = sharedOwner{pid: int32(cmd.Process.Pid)}
+	second.unlock(true)
+	if reclaimed, err := first.Reclaim(); err != nil || reclaimed != 1 {
+		t.Errorf("expected 1 slot reclaimed, got %d (%v)", reclaimed, err)
+	}
+	if _, booked := second.Owner(500); booked {
+		t.Error("slot 500 should have been reclaimed")
+	}
+
+	// So are those of a process whose PID was reused by a later one
+	if second.owner.start != 0 {
+		second.Set(500)
+		second.lock()
+		(*second.st.slice)[500].start--
+		second.unlock(true)
+		if reclaimed, err := first.Reclaim(); err != nil || reclaimed != 1 {
+			t.Errorf("expected the slot of a reused PID reclaimed, got %d (%v)", reclaimed, err)
+		}
+	}
+	if reclaimed, _ := first.Reclaim(); reclaimed != 0 {
+		t.Error("bookings of a running process should not be reclaimed", reclaimed)
+	}
+	first.Close()
+
+	// The state survives reopening
+	third, err := OpenShared[uint16](path, 1000, uint8(16), &Boundaries{100, 999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if info := third.Layout(); info.Available != 899 {
+		t.Error("unexpected available slots after reopening", info.Available)
+	}
+	var booked []uint16
+	for slot := range third.st.bitmap().booked() {
+		booked = append(booked, slot)
+	}
+	if len(booked) != 1 || booked[0] != 101 {
+		t.Error("unexpected booked slots", booked)
+	}
+
+	// Handles of a machine with exclusions agree on what is usable
+	path = filepath.Join(t.TempDir(), "excluded.state")
+	rs := RangeSet{Ranges: []Boundaries{{0, 127}}, Exclude: []int{0, 1, 2}}
+	a, err := OpenShared[uint16](path, 128, uint8(8), nil, WithRanges(rs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if _, err := OpenShared[uint16](path, 128, uint8(8), nil); err == nil {
+		t.Error("opening without the exclusions should be refused")
+	}
+	b, err := OpenShared[uint16](path, 128, uint8(8), nil, WithRanges(rs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if _, err := b.Unset(0); err == nil {
+		t.Error("an excluded slot should not be released")
+	}
+	if slot, available, err := a.Book(); err != nil || slot != 3 || available != 124 {
+		t.Errorf("expected to book slot 3 with 124 left, got %d, %d (%v)", slot, available, err)
+	}
+}
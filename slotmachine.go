@@ -3,8 +3,13 @@ package slotmachine
 import (
 	"fmt"
 	"golang.org/x/exp/constraints"
-	"math"
+	"io"
+	"iter"
+	"log/slog"
+	"math/bits"
+	"os"
 	"sync"
+	"time"
 )
 
 type ConcurrencyModel uint8
@@ -23,19 +28,32 @@ const (
 )
 
 type Boundaries struct {
-	Lower int
-	Upper int
+	Lower int `json:"lower"`
+	Upper int `json:"upper"`
 }
 
 type SlotMachineStruct[T constraints.Integer, V any] struct {
 	slice        *[]V
 	empty        V
 	boundaries   Boundaries
-	bucketSize   uint8 // A bucket can only be as wide as an integer type's number of bits...
-	full         T
-	bucketLevels *[][]T
+	bucketSize   uint8 // A bucket can only be as wide as a bitmap word: 64 bits
+	full         uint64
+	bucketLevels *[][]uint64
 	m            sync.Mutex
-	debug        bool
+	logger       *slog.Logger
+	metrics      Metrics
+	subs         subscribers[T, V]
+	watermarks   []*watermark
+	hysteresis   Threshold
+	counting     bool
+	counts       [][]uint64 // Free slots below each bucket, leaf level excepted
+	mask         []uint64   // Leaf bits of slots that cannot be booked
+	ranges       RangeSet
+	runs         []Boundaries // Usable slots, as ranges with no exclusion
+	runsBefore   []uint64     // Usable slots before each run
+	usableSlots  uint
+	orphans      []T // Booked slots left outside boundaries
+	strict       bool
 	available    uint
 }
 
@@ -44,8 +62,8 @@ type SlotMachine[T constraints.Integer, V any] interface {
 		slice *[]V,
 		empty V,
 		bucketSize uint8,
-		full T,
-		bucketLevels *[][]T,
+		full uint64,
+		bucketLevels *[][]uint64,
 		boundaries *Boundaries,
 	)
 	Set(slotidx T, value V) (uint, error)
@@ -53,150 +71,257 @@ type SlotMachine[T constraints.Integer, V any] interface {
 	BookAndSet(value V) (T, uint, error)
 	BookAndSetBatch(slotcount T, value V) ([]T, uint, error)
 	DumpLayout()
-}
-
-func (s *SlotMachineStruct[T, V]) DumpLayout() {
-	width := len(*s.slice)
-	fmt.Printf("Slice size: %d (Usable slots: %d - %d)\n", width, (*s).boundaries.Lower, (*s).boundaries.Upper)
-	fmt.Printf("Bucket size: %d\n", (*s).bucketSize)
-	for {
-		bucketCount := width / int(s.bucketSize)
-		if bucketCount == 0 {
-			bucketCount = 1
-		}
-		fmt.Printf("Buckets per level: %d\n", bucketCount)
-		if bucketCount == 1 {
-			break
-		}
-		width = bucketCount
-	}
+	DumpLayoutTo(w io.Writer) error
+	Layout() LayoutInfo
+	Subscribe(buffer int) (<-chan Event[T, V], func())
+	// Watermark callbacks fire when availability crosses a threshold, in either direction.
+	// They run while the machine is held, and must not call back into it.
+	OnLowWatermark(threshold Threshold, fn func(low bool, available uint))
+	OnExhausted(fn func())
+	OnRecovered(fn func())
+	All() iter.Seq2[T, V]
+	Booked() iter.Seq[T]
+	Free() iter.Seq[T]
+	FreeRanges() iter.Seq2[T, T]
+	// Find and FindAll only visit booked slots. The predicate runs while the machine is held,
+	// and must not call back into it.
+	Find(pred func(T, V) bool) (T, bool)
+	FindAll(pred func(T, V) bool) []T
+	// CountFree returns the number of free slots in [lo, hi], Rank the number of booked slots
+	// below slotidx, and Select the k-th free slot, counting from 0.
+	CountFree(lo T, hi T) (uint, error)
+	Rank(slotidx T) (uint, error)
+	Select(k uint) (T, error)
+	// Grow adopts a larger slice, copying the current values into it. Resize grows or shrinks
+	// the current slice, and Shrink fails rather than cut off booked slots.
+	// An upper boundary at the end of the slice follows its new end.
+	Grow(newSlice *[]V) error
+	Resize(size int) error
+	Shrink(size int) error
+	// SetBoundaries changes the usable range. Booked slots left outside are reported
+	// with a *BookedOutsideError, or refused with WithStrictBoundaries.
+	SetBoundaries(b Boundaries) error
+	// SetRanges is SetBoundaries for several ranges, and exclusions.
+	SetRanges(rs RangeSet) error
+	// Get returns the value of a booked slot, or the empty value and false.
+	Get(slotidx T) (V, bool)
+	// Snapshot returns the machine's state, in a form that Restore brings back.
+	Snapshot() *Snapshot[T, V]
+	// Stats reports fragmentation: free runs, and how full the buckets below the root are.
+	Stats() Stats
 }
 
 func (s *SlotMachineStruct[T, V]) checkBoundaries(slotidx T) Validated {
-	if slotidx < T(s.boundaries.Lower) || slotidx > T(s.boundaries.Upper) {
+	if slotidx < 0 || uint64(slotidx) >= uint64(len(*s.slice)) {
+		return OutOfBound
+	}
+	bucket, offset := int(slotidx)/int(s.bucketSize), int(slotidx)%int(s.bucketSize)
+	if s.mask[bucket]&(1<<offset) != 0 {
 		return OutOfBound
 	}
 	return InBound
 }
 
-func (s *SlotMachineStruct[T, V]) set(slotidx T, value V) (uint, error) {
+func (s *SlotMachineStruct[T, V]) setSlot(slotidx T, value V) (uint, error) {
 	if s.checkBoundaries(slotidx) == OutOfBound {
+		if s.debugging() {
+			s.debug("set out of bounds", slog.Int64("slot", int64(slotidx)))
+		}
 		return s.available, fmt.Errorf("slot index %d is out of bounds", slotidx)
 	}
 
 	(*s.slice)[slotidx] = value
 
-	level := (*s.bucketLevels)[len(*s.bucketLevels)-1]
-	slicesize := len(*s.slice) / len(level)
-	bucket, offset := int(slotidx)/slicesize, int(slotidx)%slicesize
+	leafidx := len(*s.bucketLevels) - 1
+	level := (*s.bucketLevels)[leafidx]
+	bucket, offset := s.locate(leafidx, int(slotidx))
 	if level[bucket]&(1<<offset) != 0 {
 		return s.available, nil
 	}
 	level[bucket] |= (1 << offset)
 
 	s.available--
+	s.count(int(slotidx), -1)
 
 	if level[bucket] != (*s).full {
 		return s.available, nil
 	}
-	if (*s).debug {
-		fmt.Printf("bucketfull, (full=%d) slotidx=%d -> bucket=%d (width=%d), bounds=%d-%d\n", level[bucket], slotidx, bucket, len(level), bucket*slicesize, bucket*slicesize+slicesize-1)
+	if s.debugging() {
+		span := s.span(leafidx)
+		s.debug("bucket full",
+			slog.Int64("slot", int64(slotidx)),
+			slog.Int("bucket", bucket),
+			slog.Int("width", len(level)),
+			slog.Int("first", bucket*span),
+			slog.Int("last", bucket*span+span-1))
 	}
-	for levelidx := len(*s.bucketLevels) - 2; levelidx >= 0; levelidx-- {
+	for levelidx := leafidx - 1; levelidx >= 0; levelidx-- {
 		level = (*s.bucketLevels)[levelidx]
-		slicesize = len(*s.slice) / len(level)
-		bucket = int(slotidx) / slicesize
-		offset = (int(slotidx) % slicesize) / (slicesize / int((*s).bucketSize))
+		bucket, offset = s.locate(levelidx, int(slotidx))
 		level[bucket] |= (1 << offset)
 		if level[bucket] != (*s).full {
 			break
 		}
-		if (*s).debug {
-			fmt.Printf("parent bucket is %d, slicesize=%d, bounds=%d-%d offset=%d newv=%d\n", bucket, slicesize, bucket*slicesize, bucket*slicesize+slicesize-1, offset, level[bucket])
+		if s.debugging() {
+			span := s.span(levelidx)
+			s.debug("parent bucket full",
+				slog.Int("level", levelidx),
+				slog.Int("bucket", bucket),
+				slog.Int("offset", offset),
+				slog.Int("first", bucket*span),
+				slog.Int("last", bucket*span+span-1))
 		}
 	}
 
 	return s.available, nil
 }
 
-func (s *SlotMachineStruct[T, V]) unset(slotidx T) (uint, error) {
+func (s *SlotMachineStruct[T, V]) unsetSlot(slotidx T) (uint, error) {
 	if s.checkBoundaries(slotidx) == OutOfBound {
+		if s.debugging() {
+			s.debug("unset out of bounds", slog.Int64("slot", int64(slotidx)))
+		}
 		return s.available, fmt.Errorf("slot index %d is out of bounds", slotidx)
 	}
 
-	emptyVal := (*s).empty
-	var emptyIf any = emptyVal
-	(*s.slice)[slotidx] = emptyIf.(V)
+	(*s.slice)[slotidx] = s.empty
 
-	level := (*s.bucketLevels)[len(*s.bucketLevels)-1]
-	slicesize := len(*s.slice) / len(level)
-	bucket, offset := int(slotidx)/slicesize, int(slotidx)%slicesize
+	leafidx := len(*s.bucketLevels) - 1
+	level := (*s.bucketLevels)[leafidx]
+	bucket, offset := s.locate(leafidx, int(slotidx))
 	if level[bucket]&(1<<offset) == 0 {
 		return s.available, nil
 	}
+	wasFull := level[bucket] == (*s).full
 	level[bucket] &^= (1 << offset)
 
 	s.available++
+	s.count(int(slotidx), 1)
 
-	if level[bucket] == (*s).full {
-		return s.available, nil
-	}
-	for levelidx := len(*s.bucketLevels) - 2; levelidx >= 0; levelidx-- {
+	// Parents only track full buckets: they need updating if this one just stopped being full
+	for levelidx := leafidx - 1; levelidx >= 0 && wasFull; levelidx-- {
 		level = (*s.bucketLevels)[levelidx]
-		slicesize = len(*s.slice) / len(level)
-		bucket = int(slotidx) / slicesize
-		offset = (int(slotidx) % slicesize) / (slicesize / int((*s).bucketSize))
+		bucket, offset = s.locate(levelidx, int(slotidx))
+		wasFull = level[bucket] == (*s).full
 		level[bucket] &^= (1 << offset)
-		if level[bucket] == (*s).full {
-			break
+		if s.debugging() {
+			span := s.span(levelidx)
+			s.debug("parent bucket released",
+				slog.Int("level", levelidx),
+				slog.Int("bucket", bucket),
+				slog.Int("offset", offset),
+				slog.Int("first", bucket*span),
+				slog.Int("last", bucket*span+span-1))
 		}
 	}
 
 	return s.available, nil
 }
 
+func (s *SlotMachineStruct[T, V]) set(slotidx T, value V) (uint, error) {
+	watched := s.watched()
+	var old V
+	if watched {
+		old = s.valueAt(slotidx)
+	}
+	available, err := s.setSlot(slotidx, value)
+	s.observe(TransactionSet, err)
+	s.checkWatermarks()
+	if watched && err == nil {
+		s.emit(TransactionSet, slotidx, old, value)
+	}
+	return available, err
+}
+
+func (s *SlotMachineStruct[T, V]) unset(slotidx T) (uint, error) {
+	watched := s.watched()
+	var old V
+	if watched {
+		old = s.valueAt(slotidx)
+	}
+	before := s.available
+	available, err := s.unsetSlot(slotidx)
+	s.observe(TransactionUnset, err)
+	s.checkWatermarks()
+	// Releasing a slot that was already free is not a change worth reporting
+	if watched && err == nil && available != before {
+		s.emit(TransactionUnset, slotidx, old, s.empty)
+	}
+	return available, err
+}
+
 func (s *SlotMachineStruct[T, V]) bookAndSet(value V) (T, uint, error) {
-	var level []T
-	var found bool
-	var bucket int
+	slot, depth, err := s.bookSlot(value)
+	if s.metrics != nil {
+		s.metrics.SearchDepth(depth)
+	}
+	s.observe(TransactionBookAndSet, err)
+	s.checkWatermarks()
+	if err == nil && s.watched() {
+		s.emit(TransactionBookAndSet, slot, s.empty, value)
+	}
+	return slot, s.available, err
+}
 
-	for levelidx := 0; levelidx < len(*s.bucketLevels); levelidx++ {
-		found = false
-		level = (*s.bucketLevels)[levelidx]
-		for bucket = 0; bucket < len(level); bucket++ {
-			if level[bucket] != (*s).full {
-				if (*s).debug {
-					fmt.Printf("Level %d, Found slice %d\n", levelidx, bucket)
+// bookSlot descends from the root, following the first non-full child at each level,
+// and returns the booked slot along with how many buckets were inspected to find it
+func (s *SlotMachineStruct[T, V]) bookSlot(value V) (T, int, error) {
+	var bucket int
+	var depth int
+
+	for levelidx, level := range *s.bucketLevels {
+		depth++
+		free := ^level[bucket] & (*s).full
+		if free == 0 {
+			if levelidx == 0 {
+				if s.debugging() {
+					s.debug("no available slot", slog.Uint64("available", uint64(s.available)))
 				}
-				found = true
-				break
+				return 0, depth, fmt.Errorf("SlotMachine: No available slot")
+			}
+			// A parent bit said this bucket had room: the hierarchy is inconsistent
+			if s.debugging() {
+				s.debug("no usable slot", slog.Int("level", levelidx), slog.Int("bucket", bucket))
+			}
+			return 0, depth, fmt.Errorf("SlotMachine: No usable slot")
+		}
+		offset := bits.TrailingZeros64(free)
+		if s.debugging() {
+			if levelidx == len(*s.bucketLevels)-1 {
+				s.debug("search found leaf bucket", slog.Int("bucket", bucket), slog.Int("offset", offset))
 			} else {
-				slicesize := len(*s.slice) / len(level)
-				if (*s).debug {
-					fmt.Printf("Level %d, slice %d 9[[%d-%d] is full (%d)\n", levelidx, bucket, bucket*slicesize, bucket*slicesize+slicesize-1, level[bucket])
-				}
+				s.debug("search descended", slog.Int("level", levelidx), slog.Int("bucket", bucket), slog.Int("offset", offset))
 			}
 		}
+		bucket = bucket*int((*s).bucketSize) + offset
 	}
-	if !found {
-		return 0, s.available, fmt.Errorf("SlotMachine: No available slot")
+
+	slot := bucket
+	_, err := s.setSlot(T(slot), value)
+	if err != nil {
+		if s.debugging() {
+			s.debug("no usable slot", slog.Int("slot", slot), slog.String("error", err.Error()))
+		}
+		return 0, depth, fmt.Errorf("SlotMachine: No usable slot: %s", err)
 	}
-	slicesize := len(*s.slice) / len(level)
-	position := bucket * slicesize
-	if (*s).debug {
-		fmt.Printf("Found bucket %d position=%d slotidx=%d\n", bucket, bucket*slicesize, level[bucket])
+	return T(slot), depth, nil
+}
+
+// validate checks New's arguments, for a slice of size slots
+func validate[T constraints.Integer](size int, bucketSize uint8, boundaries *Boundaries) error {
+	if bucketSize < 2 || bucketSize > 64 || bucketSize&(bucketSize-1) != 0 {
+		return fmt.Errorf("bucket size must be a power of 2, between 2 and 64")
 	}
-	for i := 0; i < +slicesize; i++ {
-		if level[bucket]&(1<<i) == 0 {
-			slot := position + i
-			_, err := s.set(T(slot), value)
-			if err != nil {
-				return 0, s.available, fmt.Errorf("SlotMachine: No usable slot: %s", err)
-			}
-			return T(slot), s.available, nil
-		}
+	if size <= 0 {
+		return fmt.Errorf("slice must not be empty")
+	}
+	if uint64(size-1) > maxIndex[T]() {
+		return fmt.Errorf("slice of %d slots cannot be indexed with %T, whose largest value is %d", size, T(0), maxIndex[T]())
 	}
-	return 0, s.available, fmt.Errorf("SlotMachine: No usable slot")
+	if boundaries != nil && (boundaries.Lower < 0 || boundaries.Upper >= size || boundaries.Lower > boundaries.Upper) {
+		return fmt.Errorf("boundaries %d - %d do not fit a slice of %d slots", boundaries.Lower, boundaries.Upper, size)
+	}
+	return nil
 }
 
 func New[T constraints.Integer, V any](
@@ -205,70 +330,72 @@ func New[T constraints.Integer, V any](
 	empty V,
 	bucketSize uint8,
 	boundaries *Boundaries,
+	opts ...Option,
 ) (SlotMachine[T, V], error) {
 
-	if math.Ceil(math.Log2(float64(bucketSize))) != math.Floor(math.Log2(float64(bucketSize))) {
-		return nil, fmt.Errorf("bucket size must be a power of 2")
-	}
-	width := len(*slice)
-	if math.Ceil(math.Log2(float64(width))) != math.Floor(math.Log2(float64(width))) {
-		return nil, fmt.Errorf("for performance, the slice's size needs to be 2-aligned; suggest you resize to %d and set upper bound",
-			int(math.Pow(2.0, math.Ceil(math.Log2(float64(len(*slice)))))))
+	if err := validate[T](len(*slice), bucketSize, boundaries); err != nil {
+		return nil, err
 	}
 
-	var bucketLevels [][]T
-	for {
-		bucketCount := width / int(bucketSize)
-		if bucketCount == 0 {
-			bucketCount = 1
-		}
-		buckets := make([]T, bucketCount)
-		bucketLevels = append([][]T{buckets}, bucketLevels...)
-		if bucketCount == 1 {
-			break
-		}
-		width = bucketCount
+	bucketFull := ^uint64(0) >> (64 - bucketSize)
+	bucketLevels := buildLevels(len(*slice), bucketSize, bucketFull)
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
 
 	var bdrs *Boundaries
-	if boundaries != nil {
+	if o.ranges != nil {
+		if boundaries != nil {
+			return nil, fmt.Errorf("boundaries and ranges cannot both be set")
+		}
+		rs, err := o.ranges.normalize(len(*slice))
+		if err != nil {
+			return nil, err
+		}
+		o.ranges = &rs
+		span := rs.span()
+		bdrs = &span
+	} else if boundaries != nil {
 		bdrs = boundaries
 	} else {
 		bdrs = &Boundaries{0, len(*slice) - 1}
 	}
 
-	bucketFull := (1 << bucketSize) - 1
-
 	switch cmodel {
 	case NoConcurrency:
 		sm := NoConcurrencySlotMachine[T, V]{}
+		sm.st.apply(&o)
 		sm.Init(
 			slice,
 			empty,
 			bucketSize,
-			T(bucketFull),
+			bucketFull,
 			&bucketLevels,
 			bdrs,
 		)
 		return &sm, nil
 	case SyncConcurrency:
 		sm := SyncConcurrencySlotMachine[T, V]{}
+		sm.st.apply(&o)
 		sm.Init(
 			slice,
 			empty,
 			bucketSize,
-			T(bucketFull),
+			bucketFull,
 			&bucketLevels,
 			bdrs,
 		)
 		return &sm, nil
 	case ChannelConcurrency:
 		sm := ChannelConcurrencySlotMachine[T, V]{}
+		sm.st.apply(&o)
 		sm.Init(
 			slice,
 			empty,
 			bucketSize,
-			T(bucketFull),
+			bucketFull,
 			&bucketLevels,
 			bdrs,
 		)
@@ -286,17 +413,17 @@ func (s *NoConcurrencySlotMachine[T, V]) Init(
 	slice *[]V,
 	empty V,
 	bucketSize uint8,
-	full T,
-	bucketLevels *[][]T,
+	full uint64,
+	bucketLevels *[][]uint64,
 	boundaries *Boundaries,
 ) {
 	s.st.slice = slice
 	s.st.empty = empty
 	s.st.bucketSize = bucketSize
-	s.st.full = T(full)
+	s.st.full = full
 	s.st.bucketLevels = bucketLevels
 	s.st.boundaries = *boundaries
-	s.st.available = uint(s.st.boundaries.Upper) - uint(s.st.boundaries.Lower) + 1
+	s.st.initMask()
 }
 
 func (s *NoConcurrencySlotMachine[T, V]) Set(slotidx T, value V) (uint, error) {
@@ -328,7 +455,7 @@ func (s *NoConcurrencySlotMachine[T, V]) BookAndSetBatch(slotcount T, value V) (
 }
 
 func (s *NoConcurrencySlotMachine[T, V]) DumpLayout() {
-	s.st.DumpLayout()
+	s.DumpLayoutTo(os.Stdout)
 }
 
 type SyncConcurrencySlotMachine[T constraints.Integer, V any] struct {
@@ -339,42 +466,42 @@ func (s *SyncConcurrencySlotMachine[T, V]) Init(
 	slice *[]V,
 	empty V,
 	bucketSize uint8,
-	full T,
-	bucketLevels *[][]T,
+	full uint64,
+	bucketLevels *[][]uint64,
 	boundaries *Boundaries,
 ) {
 	s.st.slice = slice
 	s.st.empty = empty
 	s.st.bucketSize = bucketSize
-	s.st.full = T(full)
+	s.st.full = full
 	s.st.bucketLevels = bucketLevels
 	s.st.boundaries = *boundaries
-	s.st.available = uint(s.st.boundaries.Upper) - uint(s.st.boundaries.Lower) + 1
+	s.st.initMask()
 }
 
 func (s *SyncConcurrencySlotMachine[T, V]) Set(slotidx T, value V) (uint, error) {
-	s.st.m.Lock()
+	s.lock(TransactionSet)
 	defer s.st.m.Unlock()
 
 	return s.st.set(slotidx, value)
 }
 
 func (s *SyncConcurrencySlotMachine[T, V]) Unset(slotidx T) (uint, error) {
-	s.st.m.Lock()
+	s.lock(TransactionUnset)
 	defer s.st.m.Unlock()
 
 	return s.st.unset(slotidx)
 }
 
 func (s *SyncConcurrencySlotMachine[T, V]) BookAndSet(value V) (T, uint, error) {
-	s.st.m.Lock()
+	s.lock(TransactionBookAndSet)
 	defer s.st.m.Unlock()
 
 	return s.st.bookAndSet(value)
 }
 
 func (s *SyncConcurrencySlotMachine[T, V]) BookAndSetBatch(slotcount T, value V) ([]T, uint, error) {
-	s.st.m.Lock()
+	s.lock(TransactionBookAndSet)
 	defer s.st.m.Unlock()
 
 	slots := []T{}
@@ -393,7 +520,7 @@ func (s *SyncConcurrencySlotMachine[T, V]) BookAndSetBatch(slotcount T, value V)
 }
 
 func (s *SyncConcurrencySlotMachine[T, V]) DumpLayout() {
-	s.st.DumpLayout()
+	s.DumpLayoutTo(os.Stdout)
 }
 
 type TransactionType uint8
@@ -402,6 +529,7 @@ const (
 	TransactionSet TransactionType = iota
 	TransactionUnset
 	TransactionBookAndSet
+	transactionCall
 )
 
 type response[T constraints.Integer] struct {
@@ -414,6 +542,8 @@ type transact[T constraints.Integer, V any] struct {
 	ttype    TransactionType
 	slotidx  T
 	value    V
+	fn       func()
+	queued   time.Time
 	response chan response[T]
 }
 
@@ -426,23 +556,26 @@ func (s *ChannelConcurrencySlotMachine[T, V]) Init(
 	slice *[]V,
 	empty V,
 	bucketSize uint8,
-	full T,
-	bucketLevels *[][]T,
+	full uint64,
+	bucketLevels *[][]uint64,
 	boundaries *Boundaries,
 ) {
 	s.st.slice = slice
 	s.st.empty = empty
 	s.st.bucketSize = bucketSize
-	s.st.full = T(full)
+	s.st.full = full
 	s.st.bucketLevels = bucketLevels
 	s.st.boundaries = *boundaries
-	s.st.available = uint(s.st.boundaries.Upper) - uint(s.st.boundaries.Lower) + 1
+	s.st.initMask()
 
 	s.transactor = make(chan *transact[T, V], 8)
 	go func() {
 		for {
 			select {
 			case transaction := <-s.transactor:
+				if s.st.metrics != nil && transaction.ttype != transactionCall {
+					s.st.metrics.Wait(transaction.ttype, time.Since(transaction.queued))
+				}
 				switch transaction.ttype {
 				case TransactionSet:
 					available, err := s.st.set(transaction.slotidx, transaction.value)
@@ -453,28 +586,38 @@ func (s *ChannelConcurrencySlotMachine[T, V]) Init(
 				case TransactionBookAndSet:
 					n, available, err := s.st.bookAndSet(transaction.value)
 					transaction.response <- response[T]{&n, available, &err}
+				case transactionCall:
+					transaction.fn()
+					transaction.response <- response[T]{}
 				}
 			}
 		}
 	}()
 }
 
+// call runs fn on the transactor goroutine, so that it sees a consistent state
+func (s *ChannelConcurrencySlotMachine[T, V]) call(fn func()) {
+	tr := &transact[T, V]{ttype: transactionCall, fn: fn, response: make(chan response[T])}
+	s.transactor <- tr
+	<-tr.response
+}
+
 func (s *ChannelConcurrencySlotMachine[T, V]) Set(slotidx T, value V) (uint, error) {
-	tr := &transact[T, V]{ttype: TransactionSet, slotidx: slotidx, value: value, response: make(chan response[T])}
+	tr := &transact[T, V]{ttype: TransactionSet, slotidx: slotidx, value: value, queued: s.st.queued(), response: make(chan response[T])}
 	s.transactor <- tr
 	response := <-tr.response
 	return response.available, *response.err
 }
 
 func (s *ChannelConcurrencySlotMachine[T, V]) Unset(slotidx T) (uint, error) {
-	tr := &transact[T, V]{ttype: TransactionUnset, slotidx: slotidx, response: make(chan response[T])}
+	tr := &transact[T, V]{ttype: TransactionUnset, slotidx: slotidx, queued: s.st.queued(), response: make(chan response[T])}
 	s.transactor <- tr
 	response := <-tr.response
 	return response.available, *response.err
 }
 
 func (s *ChannelConcurrencySlotMachine[T, V]) BookAndSet(value V) (T, uint, error) {
-	tr := &transact[T, V]{ttype: TransactionBookAndSet, value: value, response: make(chan response[T])}
+	tr := &transact[T, V]{ttype: TransactionBookAndSet, value: value, queued: s.st.queued(), response: make(chan response[T])}
 	s.transactor <- tr
 	response := <-tr.response
 	return *response.slotidx, response.available, *response.err
@@ -487,7 +630,7 @@ func (s *ChannelConcurrencySlotMachine[T, V]) BookAndSetBatch(slotcount T, value
 	var available uint
 	var err error
 	for i := 0; i < int(slotcount); i++ {
-		tr := &transact[T, V]{ttype: TransactionBookAndSet, value: value, response: make(chan response[T])}
+		tr := &transact[T, V]{ttype: TransactionBookAndSet, value: value, queued: s.st.queued(), response: make(chan response[T])}
 		s.transactor <- tr
 		response := <-tr.response
 		n, available, err = *response.slotidx, response.available, *response.err
@@ -501,5 +644,5 @@ func (s *ChannelConcurrencySlotMachine[T, V]) BookAndSetBatch(slotcount T, value
 }
 
 func (s *ChannelConcurrencySlotMachine[T, V]) DumpLayout() {
-	s.st.DumpLayout()
+	s.DumpLayoutTo(os.Stdout)
 }
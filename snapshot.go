@@ -0,0 +1,93 @@
+package slotmachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"golang.org/x/exp/constraints"
+)
+
+// SnapshotSlot is a booked slot and its value.
+type SnapshotSlot[T constraints.Integer, V any] struct {
+	Slot  T `json:"slot"`
+	Value V `json:"value"`
+}
+
+// Snapshot is the persistence format of a slot machine: its geometry, its usable slots,
+// and its booked slots with their values. Slots booked outside the ranges are not kept.
+type Snapshot[T constraints.Integer, V any] struct {
+	Size       int                  `json:"size"`
+	BucketSize uint8                `json:"bucketSize"`
+	Empty      V                    `json:"empty"`
+	Ranges     RangeSet             `json:"ranges"`
+	Slots      []SnapshotSlot[T, V] `json:"slots"`
+}
+
+func (s *SlotMachineStruct[T, V]) snapshotState() *Snapshot[T, V] {
+	snap := &Snapshot[T, V]{
+		Size:       len(*s.slice),
+		BucketSize: s.bucketSize,
+		Empty:      s.empty,
+		Ranges:     s.ranges,
+		Slots:      []SnapshotSlot[T, V]{},
+	}
+	for slot, value := range s.all() {
+		snap.Slots = append(snap.Slots, SnapshotSlot[T, V]{slot, value})
+	}
+	return snap
+}
+
+// WriteJSON writes the snapshot as JSON.
+func (snap *Snapshot[T, V]) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// ReadSnapshot reads a snapshot written by WriteJSON.
+func ReadSnapshot[T constraints.Integer, V any](r io.Reader) (*Snapshot[T, V], error) {
+	var snap Snapshot[T, V]
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("SlotMachine: cannot read snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// Restore creates a slot machine, over a new slice, from a snapshot. Options are those of New.
+func Restore[T constraints.Integer, V any](cmodel ConcurrencyModel, snap *Snapshot[T, V], opts ...Option) (SlotMachine[T, V], *[]V, error) {
+	slice := make([]V, snap.Size)
+	for i := range slice {
+		slice[i] = snap.Empty
+	}
+	if len(snap.Ranges.Ranges) > 0 {
+		opts = append([]Option{WithRanges(snap.Ranges)}, opts...)
+	}
+	sm, err := New[T, V](cmodel, &slice, snap.Empty, snap.BucketSize, nil, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, booked := range snap.Slots {
+		if _, err := sm.Set(booked.Slot, booked.Value); err != nil {
+			return nil, nil, fmt.Errorf("SlotMachine: cannot restore snapshot: %w", err)
+		}
+	}
+	return sm, &slice, nil
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Snapshot() *Snapshot[T, V] {
+	return s.st.snapshotState()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Snapshot() *Snapshot[T, V] {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.snapshotState()
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Snapshot() *Snapshot[T, V] {
+	var snap *Snapshot[T, V]
+	s.call(func() {
+		snap = s.st.snapshotState()
+	})
+	return snap
+}
@@ -0,0 +1,156 @@
+package slotmachine
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+// BucketFill counts the usable and free slots under a bucket.
+type BucketFill struct {
+	Usable uint `json:"usable"`
+	Free   uint `json:"free"`
+}
+
+// Ratio returns the share of usable slots that are booked, 0 when there is none.
+func (b BucketFill) Ratio() float64 {
+	if b.Usable == 0 {
+		return 0
+	}
+	return float64(b.Usable-b.Free) / float64(b.Usable)
+}
+
+// Stats describes how fragmented a slot machine is.
+// Buckets are those of the level below the root, or the root alone for a single level machine.
+type Stats struct {
+	Usable         uint         `json:"usable"`
+	Available      uint         `json:"available"`
+	FreeRuns       int          `json:"freeRuns"`
+	LargestFreeRun uint         `json:"largestFreeRun"`
+	BucketSpan     int          `json:"bucketSpan"` // Slots under each bucket
+	Buckets        []BucketFill `json:"buckets"`
+}
+
+func (s *SlotMachineStruct[T, V]) stats() Stats {
+	levelidx := min(1, len(*s.bucketLevels)-1)
+	span := s.span(levelidx)
+	st := Stats{
+		Usable:     s.usable(),
+		Available:  s.available,
+		BucketSpan: span,
+		Buckets:    make([]BucketFill, len((*s.bucketLevels)[levelidx])),
+	}
+	// Spreads [first, last] over the buckets it covers
+	spread := func(first int, last int, add func(b *BucketFill, n uint)) {
+		for lo := first; lo <= last; {
+			hi := min(last, (lo/span+1)*span-1)
+			add(&st.Buckets[lo/span], uint(hi-lo+1))
+			lo = hi + 1
+		}
+	}
+	for _, r := range s.runs {
+		spread(r.Lower, r.Upper, func(b *BucketFill, n uint) { b.Usable += n })
+	}
+	for first, last := range s.bitmap().freeRanges() {
+		st.FreeRuns++
+		st.LargestFreeRun = max(st.LargestFreeRun, uint(last-first+1))
+		spread(int(first), int(last), func(b *BucketFill, n uint) { b.Free += n })
+	}
+	return st
+}
+
+// Darker is fuller
+const heatmapRamp = ".:-=+*#%@"
+
+// WriteHeatmap draws one character per bucket, width buckets per line, from '.' for an empty
+// bucket to '@' for a full one. Buckets with no usable slot are blank.
+func (st Stats) WriteHeatmap(w io.Writer, width int) error {
+	if width <= 0 {
+		return fmt.Errorf("SlotMachine: heatmap width must be positive, not %d", width)
+	}
+	var sb strings.Builder
+	for i, b := range st.Buckets {
+		if i%width == 0 {
+			fmt.Fprintf(&sb, "%10d ", i*st.BucketSpan)
+		}
+		if b.Usable == 0 {
+			sb.WriteByte(' ')
+		} else {
+			sb.WriteByte(heatmapRamp[int(b.Ratio()*float64(len(heatmapRamp)-1)+0.5)])
+		}
+		if i%width == width-1 || i == len(st.Buckets)-1 {
+			sb.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// heatColor goes from green, for an empty bucket, to red for a full one. Buckets with no
+// usable slot are grey.
+func heatColor(b BucketFill) color.RGBA {
+	if b.Usable == 0 {
+		return color.RGBA{0xc0, 0xc0, 0xc0, 0xff}
+	}
+	r := b.Ratio()
+	return color.RGBA{uint8(255 * r), uint8(200 * (1 - r)), 0x40, 0xff}
+}
+
+// WriteSVG draws the heatmap as an SVG image, with cells of cell pixels, width cells per row.
+func (st Stats) WriteSVG(w io.Writer, width int, cell int) error {
+	if width <= 0 || cell <= 0 {
+		return fmt.Errorf("SlotMachine: heatmap width and cell size must be positive")
+	}
+	rows := (len(st.Buckets) + width - 1) / width
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d">`+"\n", min(width, len(st.Buckets))*cell, rows*cell)
+	for i, b := range st.Buckets {
+		c := heatColor(b)
+		fmt.Fprintf(&sb, `<rect x="%d" y="%d" width="%d" height="%d" fill="#%02x%02x%02x"><title>%d: %d/%d free</title></rect>`+"\n",
+			i%width*cell, i/width*cell, cell, cell, c.R, c.G, c.B, i*st.BucketSpan, b.Free, b.Usable)
+	}
+	sb.WriteString("</svg>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WritePNG draws the heatmap as a PNG image, with cells of cell pixels, width cells per row.
+func (st Stats) WritePNG(w io.Writer, width int, cell int) error {
+	if width <= 0 || cell <= 0 {
+		return fmt.Errorf("SlotMachine: heatmap width and cell size must be positive")
+	}
+	rows := (len(st.Buckets) + width - 1) / width
+	img := image.NewRGBA(image.Rect(0, 0, min(width, len(st.Buckets))*cell, rows*cell))
+	for i, b := range st.Buckets {
+		c := heatColor(b)
+		x0, y0 := i%width*cell, i/width*cell
+		for y := y0; y < y0+cell; y++ {
+			for x := x0; x < x0+cell; x++ {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) Stats() Stats {
+	return s.st.stats()
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) Stats() Stats {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	return s.st.stats()
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) Stats() Stats {
+	var st Stats
+	s.call(func() {
+		st = s.st.stats()
+	})
+	return st
+}
@@ -0,0 +1,137 @@
+package slotmachine
+
+// Threshold is a number of available slots, either absolute or relative to the number of usable slots.
+type Threshold struct {
+	Slots   uint
+	Percent float64 // Takes precedence over Slots when non-zero
+}
+
+// AbsoluteThreshold returns a threshold of n available slots.
+func AbsoluteThreshold(n uint) Threshold {
+	return Threshold{Slots: n}
+}
+
+// PercentThreshold returns a threshold of p percent (0-100) of the usable slots.
+func PercentThreshold(p float64) Threshold {
+	return Threshold{Percent: p}
+}
+
+// WithHysteresis sets how far above a watermark availability must climb before the watermark
+// is considered cleared, so that a pool hovering around a threshold does not keep firing callbacks.
+// The default is no hysteresis: callbacks still only fire when crossing a watermark.
+func WithHysteresis(margin Threshold) Option {
+	return func(o *options) {
+		o.hysteresis = margin
+	}
+}
+
+type watermark struct {
+	threshold Threshold
+	low       bool
+	fn        func(low bool, available uint)
+}
+
+func (s *SlotMachineStruct[T, V]) usable() uint {
+	return s.usableSlots
+}
+
+func (s *SlotMachineStruct[T, V]) slotsFor(threshold Threshold) uint {
+	if threshold.Percent != 0 {
+		return uint(threshold.Percent / 100 * float64(s.usable()))
+	}
+	return threshold.Slots
+}
+
+// A watermark starts in whatever state the machine is in when it is registered:
+// callbacks only fire on later crossings
+func (s *SlotMachineStruct[T, V]) onLowWatermark(threshold Threshold, fn func(low bool, available uint)) {
+	s.watermarks = append(s.watermarks, &watermark{
+		threshold: threshold,
+		low:       s.available <= s.slotsFor(threshold),
+		fn:        fn,
+	})
+}
+
+func (s *SlotMachineStruct[T, V]) onExhausted(fn func()) {
+	s.onLowWatermark(AbsoluteThreshold(0), func(low bool, available uint) {
+		if low {
+			fn()
+		}
+	})
+}
+
+func (s *SlotMachineStruct[T, V]) onRecovered(fn func()) {
+	s.onLowWatermark(AbsoluteThreshold(0), func(low bool, available uint) {
+		if !low {
+			fn()
+		}
+	})
+}
+
+func (s *SlotMachineStruct[T, V]) checkWatermarks() {
+	if len(s.watermarks) == 0 {
+		return
+	}
+	margin := s.slotsFor(s.hysteresis)
+	for _, w := range s.watermarks {
+		level := s.slotsFor(w.threshold)
+		if !w.low && s.available <= level {
+			w.low = true
+			w.fn(true, s.available)
+		} else if w.low && s.available > level+margin {
+			w.low = false
+			w.fn(false, s.available)
+		}
+	}
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) OnLowWatermark(threshold Threshold, fn func(low bool, available uint)) {
+	s.st.onLowWatermark(threshold, fn)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) OnExhausted(fn func()) {
+	s.st.onExhausted(fn)
+}
+
+func (s *NoConcurrencySlotMachine[T, V]) OnRecovered(fn func()) {
+	s.st.onRecovered(fn)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) OnLowWatermark(threshold Threshold, fn func(low bool, available uint)) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	s.st.onLowWatermark(threshold, fn)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) OnExhausted(fn func()) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	s.st.onExhausted(fn)
+}
+
+func (s *SyncConcurrencySlotMachine[T, V]) OnRecovered(fn func()) {
+	s.st.m.Lock()
+	defer s.st.m.Unlock()
+
+	s.st.onRecovered(fn)
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) OnLowWatermark(threshold Threshold, fn func(low bool, available uint)) {
+	s.call(func() {
+		s.st.onLowWatermark(threshold, fn)
+	})
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) OnExhausted(fn func()) {
+	s.call(func() {
+		s.st.onExhausted(fn)
+	})
+}
+
+func (s *ChannelConcurrencySlotMachine[T, V]) OnRecovered(fn func()) {
+	s.call(func() {
+		s.st.onRecovered(fn)
+	})
+}